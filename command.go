@@ -0,0 +1,109 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"toolman.org/svc/build/go/gogetter/internal/config"
+)
+
+const commandUsage = `usage:
+    gogetter [flags] resync                          Resync all repositories
+    gogetter [flags] resync repo <owner/name | ID>   Resync a single repository
+    gogetter [flags] resync owner <login | ID>       Resync all repos for one installation`
+
+// command runs an admin subcommand against an already running server.
+func command(ctx context.Context, cfg *config.Config, args []string) error {
+	switch args[0] {
+	case "resync":
+		return resync(ctx, cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandUsage)
+	}
+}
+
+func resync(ctx context.Context, cfg *config.Config, args []string) error {
+	q := make(url.Values)
+
+	switch len(args) {
+	case 0:
+	case 2:
+		if args[0] != "repo" && args[0] != "owner" {
+			return fmt.Errorf("unknown resync target %q\n%s", args[0], commandUsage)
+		}
+		q.Set(args[0], args[1])
+	default:
+		return errors.New(commandUsage)
+	}
+
+	return adminRequest(ctx, cfg, http.MethodPost, "/admin/api/resync?"+q.Encode(), os.Stdout)
+}
+
+// adminRequest sends an authenticated request to the admin API of the
+// server at cfg.AdminURL and copies the response body to out.
+func adminRequest(ctx context.Context, cfg *config.Config, method, uri string, out io.Writer) error {
+	if cfg.AdminToken == "" {
+		return errors.New("no admin token configured")
+	}
+
+	base := cfg.AdminURL
+	if base == "" {
+		if cfg.Port == 0 {
+			return errors.New("--admin-url must be specified when not listening on a TCP port")
+		}
+		// With --tls-port, --port only redirects to HTTPS and a localhost
+		// URL wouldn't match the server's certificate.
+		if cfg.TLSPort != 0 {
+			return errors.New("--admin-url must be specified when --tls-port is set")
+		}
+		base = fmt.Sprintf("http://localhost:%d", cfg.Port)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+uri, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s: %s: %s", method, uri, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
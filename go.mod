@@ -4,10 +4,12 @@ go 1.12
 
 require (
 	github.com/bradleyfalzon/ghinstallation v0.1.2
-	github.com/google/go-github/v25 v25.1.1
+	github.com/google/go-github/v27 v27.0.6
 	github.com/gorilla/mux v1.7.2
 	github.com/kr/pretty v0.1.0
+	github.com/prometheus/client_golang v0.9.4
 	github.com/spf13/pflag v1.0.3
+	golang.org/x/crypto v0.0.0-20190611184440-5c40567a22f8
 	toolman.org/base/basecfg v0.1.1
 	toolman.org/base/log/v2 v2.1.0
 	toolman.org/base/toolman/v2 v2.1.2
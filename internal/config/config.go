@@ -23,6 +23,7 @@ package config
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/spf13/pflag"
 	"toolman.org/base/basecfg"
@@ -35,18 +36,52 @@ const (
 	etcdEndpoint    = "https://cfg.toolman.org:2379"
 	etcdConfigKey   = "/config/gogetter.yaml"
 	requireOauth    = false
+	defaultResync   = time.Hour
+	defaultStale    = 3 * time.Hour
+	defaultDelivLog = 1000
+	defaultQueue    = 1000
+	defaultWorkers  = 4
+	defaultRetries  = 5
+	defaultGrace    = 30 * 24 * time.Hour
+)
+
+// Repository state policies; see Config.ArchivedRepos and friends.
+const (
+	PolicyServe = "serve"
+	PolicyDrop  = "drop"
 )
 
 type Config struct {
-	Hostname      string      `cfg:"hostname"`
-	Port          int64       `cfg:"port"`
-	Socket        string      `cfg:"socket"`
-	LogDir        string      `cfg:"logdir"`
-	ClientID      string      `cfg:"client-id"`
-	IntegrationID int         `cfg:"integration-id"`
-	HookSecret    string      `cfg:"hook-secret"`
-	Trans         []*TransDef `cfg:"translators"`
-	APIKey        string      `cfg:"api-key"`
+	Hostname      string        `cfg:"hostname"`
+	Port          int64         `cfg:"port"`
+	Socket        string        `cfg:"socket"`
+	Listen        []string      `cfg:"listen,flow"`
+	LogDir        string        `cfg:"logdir"`
+	ClientID      string        `cfg:"client-id"`
+	IntegrationID int           `cfg:"integration-id"`
+	HookSecret    string        `cfg:"hook-secret"`
+	Trans         []*TransDef   `cfg:"translators"`
+	APIKey        string        `cfg:"api-key"`
+	Resync        time.Duration `cfg:"resync"`
+	StaleAfter    time.Duration `cfg:"stale-after"`
+	Snapshot      string        `cfg:"snapshot"`
+	AdminToken    string        `cfg:"admin-token"`
+	AdminURL      string        `cfg:"admin-url"`
+	TLSPort       int64         `cfg:"tls-port"`
+	TLSCert       string        `cfg:"tls-cert"`
+	TLSKey        string        `cfg:"tls-key"`
+	ACMECache     string        `cfg:"acme-cache"`
+	ACMEEmail     string        `cfg:"acme-email"`
+	HTTPGoGet     bool          `cfg:"http-goget"`
+	DeliveryLog   int           `cfg:"delivery-log"`
+	HookQueue     int           `cfg:"hook-queue"`
+	HookWorkers   int           `cfg:"hook-workers"`
+	HookTries     int           `cfg:"hook-tries"`
+	RenameGrace   time.Duration `cfg:"rename-grace"`
+	ArchivedRepos string        `cfg:"archived-repos"`
+	ForkRepos     string        `cfg:"fork-repos"`
+	TemplateRepos string        `cfg:"template-repos"`
+	DisabledRepos string        `cfg:"disabled-repos"`
 
 	*basecfg.Config
 }
@@ -54,13 +89,28 @@ type Config struct {
 type TransDef struct {
 	Prefix string   `cfg:"prefix"`
 	Owners []string `cfg:"owners,flow"`
+	Allow  []string `cfg:"allow,flow"` // Repos always treated as Go (name or owner/name patterns)
+	Deny   []string `cfg:"deny,flow"`  // Repos never served
+	Topic  string   `cfg:"topic"`      // Repo topic marking Go repos (default "go-module")
 }
 
 func New() *Config {
 	pflag.ErrHelp = errors.New("")
 
 	c := &Config{
-		Hostname: defaultHostname,
+		Hostname:    defaultHostname,
+		Resync:      defaultResync,
+		StaleAfter:  defaultStale,
+		DeliveryLog: defaultDelivLog,
+		HookQueue:   defaultQueue,
+		HookWorkers: defaultWorkers,
+		HookTries:   defaultRetries,
+		RenameGrace: defaultGrace,
+
+		ArchivedRepos: PolicyServe,
+		ForkRepos:     PolicyDrop,
+		TemplateRepos: PolicyServe,
+		DisabledRepos: PolicyDrop,
 	}
 
 	// c.Config = basecfg.New(commandName(), basecfg.Base(c), basecfg.EtcdProvider(etcdEndpoint, etcdConfigKey))
@@ -73,7 +123,29 @@ func (c *Config) FlagSet(fs *pflag.FlagSet) {
 	fs.StringVar(&c.Hostname, "hostname", c.Hostname, "Service's public hostname (for callback URL)")
 
 	fs.Int64Var(&c.Port, "port", 0, "TCP Listen Port")
-	fs.StringVar(&c.Socket, "socket", "", "FastCGI Unix-Domain Socket")
+	fs.StringVar(&c.Socket, "socket", "", "FastCGI Unix-Domain Socket (or \"systemd\" for sockets passed in by systemd)")
+	fs.StringSliceVar(&c.Listen, "listen", c.Listen, "Additional listen addresses (e.g. fcgi+tcp://127.0.0.1:9000, http+unix:///run/gogetter.sock)")
+
+	fs.Int64Var(&c.TLSPort, "tls-port", 0, "TLS Listen Port (--port then only redirects to HTTPS)")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "TLS certificate file")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "TLS private key file")
+	fs.StringVar(&c.ACMECache, "acme-cache", c.ACMECache, "Directory for caching ACME certificates (enables ACME)")
+	fs.StringVar(&c.ACMEEmail, "acme-email", c.ACMEEmail, "Contact email for the ACME account")
+	fs.BoolVar(&c.HTTPGoGet, "http-goget", c.HTTPGoGet, "Answer go-get requests on --port instead of redirecting them to HTTPS")
+
+	fs.DurationVar(&c.Resync, "resync", c.Resync, "Interval between full repository resyncs (0 disables)")
+	fs.DurationVar(&c.StaleAfter, "stale-after", c.StaleAfter, "Report not ready if the last resync is older than this (0 disables)")
+	fs.DurationVar(&c.RenameGrace, "rename-grace", c.RenameGrace, "How long old import paths are served after a repo is renamed or transferred (0 disables)")
+	fs.StringVar(&c.ArchivedRepos, "archived-repos", c.ArchivedRepos, "Policy for archived repos: serve (marked as archived) or drop")
+	fs.StringVar(&c.ForkRepos, "fork-repos", c.ForkRepos, "Policy for forked repos: serve or drop")
+	fs.StringVar(&c.TemplateRepos, "template-repos", c.TemplateRepos, "Policy for template repos: serve or drop")
+	fs.StringVar(&c.DisabledRepos, "disabled-repos", c.DisabledRepos, "Policy for disabled repos: serve or drop")
+	fs.StringVar(&c.Snapshot, "snapshot", c.Snapshot, "File used to persist the translation table across restarts")
+	fs.IntVar(&c.DeliveryLog, "delivery-log", c.DeliveryLog, "Number of recent webhook deliveries remembered for deduplication (0 disables)")
+	fs.IntVar(&c.HookQueue, "hook-queue", c.HookQueue, "Maximum number of webhook events awaiting processing (0 is unlimited)")
+	fs.IntVar(&c.HookWorkers, "hook-workers", c.HookWorkers, "Number of webhook events processed concurrently")
+	fs.IntVar(&c.HookTries, "hook-tries", c.HookTries, "Attempts made at processing a webhook event before giving up")
+	fs.StringVar(&c.AdminURL, "admin-url", c.AdminURL, "Base URL of a running server (for admin commands; default http://localhost:<port> unless --tls-port is set)")
 }
 
 func (c *Config) Validate() error {
@@ -86,12 +158,27 @@ func (c *Config) Validate() error {
 		return errors.New("config has no Github API Key")
 	}
 
-	if c.Port == 0 && c.Socket == "" {
-		return errors.New("must specify one of --port or --socket")
+	if c.Port == 0 && c.Socket == "" && c.TLSPort == 0 && len(c.Listen) == 0 {
+		return errors.New("must specify one of --port, --tls-port, --socket or --listen")
+	}
+
+	if _, err := c.Endpoints(); err != nil {
+		return err
 	}
 
-	if c.Port != 0 && c.Socket != "" {
-		return errors.New("only one of --port or --socket may be specified")
+	for k, v := range map[string]string{
+		"archived-repos": c.ArchivedRepos,
+		"fork-repos":     c.ForkRepos,
+		"template-repos": c.TemplateRepos,
+		"disabled-repos": c.DisabledRepos,
+	} {
+		if v != PolicyServe && v != PolicyDrop {
+			return fmt.Errorf("invalid --%s policy %q: must be %q or %q", k, v, PolicyServe, PolicyDrop)
+		}
+	}
+
+	if err := c.validateTLS(); err != nil {
+		return err
 	}
 
 	if c.IntegrationID == 0 {
@@ -114,6 +201,30 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+func (c *Config) validateTLS() error {
+	static := c.TLSCert != "" || c.TLSKey != ""
+
+	if c.TLSPort == 0 {
+		if static || c.ACMECache != "" {
+			return errors.New("TLS certificate options require --tls-port")
+		}
+		return nil
+	}
+
+	switch {
+	case static && c.ACMECache != "":
+		return errors.New("only one of --tls-cert/--tls-key or --acme-cache may be specified")
+
+	case static && (c.TLSCert == "" || c.TLSKey == ""):
+		return errors.New("both --tls-cert and --tls-key are required")
+
+	case !static && c.ACMECache == "":
+		return errors.New("--tls-port requires either --tls-cert/--tls-key or --acme-cache")
+	}
+
+	return nil
+}
+
 const logDirFlag = "log_dir"
 
 func (c *Config) deriveLogDir() string {
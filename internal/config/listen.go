@@ -0,0 +1,112 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Endpoint is a parsed --listen address such as "fcgi+tcp://127.0.0.1:9000"
+// or "http+unix:///run/gogetter.sock". Proto is one of "http", "fcgi" or
+// "systemd" (for sockets passed in by systemd, in which case Network and
+// Addr are empty) and Network is either "tcp" or "unix".
+type Endpoint struct {
+	Proto   string
+	Network string
+	Addr    string
+}
+
+func (e *Endpoint) String() string {
+	if e.Proto == "systemd" {
+		return "systemd://"
+	}
+	return e.Proto + "+" + e.Network + "://" + e.Addr
+}
+
+// ParseEndpoint parses a URL-style listener address. The scheme names the
+// protocol and, optionally, the network as in "http+unix"; the network
+// defaults to "tcp". For unix sockets, the remainder of the URL is taken
+// as the socket path; tcp addresses must have no path at all.
+func ParseEndpoint(addr string) (*Endpoint, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %v", addr, err)
+	}
+
+	proto, network := u.Scheme, "tcp"
+	if i := strings.IndexByte(proto, '+'); i >= 0 {
+		proto, network = proto[:i], proto[i+1:]
+	}
+
+	ep := &Endpoint{Proto: proto, Network: network}
+
+	switch proto {
+	case "http", "fcgi":
+	case "systemd":
+		return &Endpoint{Proto: proto}, nil
+	case "":
+		return nil, fmt.Errorf("listen address %q has no scheme", addr)
+	default:
+		return nil, fmt.Errorf("listen address %q: unsupported protocol %q", addr, proto)
+	}
+
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("listen address %q: unexpected userinfo, query or fragment", addr)
+	}
+
+	switch network {
+	case "tcp":
+		if u.Path != "" {
+			return nil, fmt.Errorf("listen address %q: unexpected path %q for tcp", addr, u.Path)
+		}
+		ep.Addr = u.Host
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("listen address %q has no socket path", addr)
+		}
+		ep.Addr = u.Host + u.Path
+	default:
+		return nil, fmt.Errorf("listen address %q: unsupported network %q", addr, network)
+	}
+
+	if ep.Addr == "" {
+		return nil, fmt.Errorf("listen address %q has no %s address", addr, network)
+	}
+
+	return ep, nil
+}
+
+// Endpoints returns the parsed --listen addresses.
+func (c *Config) Endpoints() ([]*Endpoint, error) {
+	eps := make([]*Endpoint, 0, len(c.Listen))
+
+	for _, addr := range c.Listen {
+		ep, err := ParseEndpoint(addr)
+		if err != nil {
+			return nil, err
+		}
+		eps = append(eps, ep)
+	}
+
+	return eps, nil
+}
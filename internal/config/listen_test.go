@@ -0,0 +1,64 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package config
+
+import "testing"
+
+func TestParseEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want *Endpoint // nil if an error is expected
+	}{
+		{"http://127.0.0.1:8080", &Endpoint{"http", "tcp", "127.0.0.1:8080"}},
+		{"http+tcp://:8080", &Endpoint{"http", "tcp", ":8080"}},
+		{"fcgi+tcp://127.0.0.1:9000", &Endpoint{"fcgi", "tcp", "127.0.0.1:9000"}},
+		{"fcgi+tcp://[::1]:9000", &Endpoint{"fcgi", "tcp", "[::1]:9000"}},
+		{"http+unix:///run/gogetter.sock", &Endpoint{"http", "unix", "/run/gogetter.sock"}},
+		{"fcgi+unix://run/gogetter.sock", &Endpoint{"fcgi", "unix", "run/gogetter.sock"}},
+		{"systemd://", &Endpoint{Proto: "systemd"}},
+
+		{"fcgi+tcp://127.0.0.1:9000/run/x.sock", nil},
+		{"http://127.0.0.1:8080/", nil},
+		{"http://127.0.0.1:8080?x=y", nil},
+		{"http://user@127.0.0.1:8080", nil},
+		{"http://127.0.0.1:8080#frag", nil},
+		{"http+tcp://", nil},
+		{"http+unix://gogetter.sock", nil},
+		{"http+unix://", nil},
+		{"http+unix:///run/gogetter.sock?mode=0600", nil},
+		{"http+udp://127.0.0.1:8080", nil},
+		{"https://127.0.0.1:8443", nil},
+		{"127.0.0.1:8080", nil},
+		{"", nil},
+		{"http://[::1", nil},
+	} {
+		got, err := ParseEndpoint(tc.addr)
+
+		switch {
+		case tc.want == nil && err == nil:
+			t.Errorf("ParseEndpoint(%q) = %+v; wanted error", tc.addr, got)
+		case tc.want != nil && err != nil:
+			t.Errorf("ParseEndpoint(%q) failed: %v", tc.addr, err)
+		case tc.want != nil && *got != *tc.want:
+			t.Errorf("ParseEndpoint(%q) = %+v; wanted %+v", tc.addr, got, tc.want)
+		}
+	}
+}
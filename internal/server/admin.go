@@ -0,0 +1,222 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"toolman.org/base/log/v2"
+	"toolman.org/net/http/httperr"
+
+	"toolman.org/svc/build/go/gogetter/internal/xlat"
+)
+
+// addAdminRoutes registers the admin API endpoints with r. All admin
+// requests must carry an "Authorization: Bearer <token>" header matching
+// the configured admin token; if no token is configured, the admin API is
+// disabled entirely.
+func (s *Server) addAdminRoutes(r *mux.Router) {
+	ar := r.PathPrefix("/admin/api").Subrouter()
+	ar.Use(s.adminAuth)
+
+	ar.Methods(http.MethodGet).Path("/table").Handler(httperr.Handler(s.adminTable))
+	ar.Methods(http.MethodGet).Path("/lookup").Handler(httperr.Handler(s.adminLookup))
+	ar.Methods(http.MethodGet).Path("/owners").Handler(httperr.Handler(s.adminOwners))
+	ar.Methods(http.MethodPost).Path("/resync").Handler(httperr.Handler(s.adminResync))
+	ar.Methods(http.MethodGet).Path("/deliveries").Handler(httperr.Handler(s.adminDeliveries))
+	ar.Methods(http.MethodGet).Path("/deadletters").Handler(httperr.Handler(s.adminDeadLetters))
+}
+
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		const scheme = "Bearer "
+
+		h := r.Header.Get("Authorization")
+		if !strings.HasPrefix(h, scheme) || subtle.ConstantTimeCompare([]byte(h[len(scheme):]), []byte(s.AdminToken)) != 1 {
+			log.Warningf("Unauthorized admin request from %s: %s", r.RemoteAddr, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gogetter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type repoJSON struct {
+	ID             int64         `json:"id"`
+	InstallationID int64         `json:"installation_id"`
+	Owner          string        `json:"owner"`
+	Name           string        `json:"name"`
+	Private        bool          `json:"private"`
+	Archived       bool          `json:"archived,omitempty"`
+	Description    string        `json:"description,omitempty"`
+	HTMLURL        string        `json:"html_url"`
+	Branch         string        `json:"default_branch"`
+	PublicURL      string        `json:"public_url"`
+	PrivateURL     string        `json:"private_url"`
+	Updated        time.Time     `json:"updated"`
+	Modules        []*moduleJSON `json:"modules"`
+}
+
+type moduleJSON struct {
+	Path    string `json:"path"`
+	Dir     string `json:"dir,omitempty"`
+	MovedTo string `json:"moved_to,omitempty"`
+}
+
+func newRepoJSON(r *xlat.Repo) *repoJSON {
+	rj := &repoJSON{
+		ID:             r.ID(),
+		InstallationID: r.InstallationID(),
+		Owner:          r.Owner(),
+		Name:           r.Name(),
+		Private:        r.Private(),
+		Archived:       r.Archived(),
+		Description:    r.Description(),
+		HTMLURL:        r.HTMLURL(),
+		Branch:         r.Branch(),
+		PublicURL:      r.PublicURL(),
+		PrivateURL:     r.PrivateURL(),
+		Updated:        r.Updated(),
+	}
+
+	for _, m := range r.Modules() {
+		rj.Modules = append(rj.Modules, &moduleJSON{Path: m.Path(), Dir: m.Dir()})
+	}
+
+	return rj
+}
+
+func (s *Server) adminTable(w http.ResponseWriter, r *http.Request) error {
+	out := []*repoJSON{}
+	for _, repo := range s.trans.Repos() {
+		out = append(out, newRepoJSON(repo))
+	}
+
+	return writeJSON(w, struct {
+		Ready bool        `json:"ready"`
+		Repos []*repoJSON `json:"repos"`
+	}{s.trans.Ready(), out})
+}
+
+func (s *Server) adminLookup(w http.ResponseWriter, r *http.Request) error {
+	ipath := r.FormValue("path")
+	if ipath == "" {
+		return httperr.LogErrorf("missing path parameter").WithOptions(httperr.Status(http.StatusBadRequest))
+	}
+
+	mod, trace := s.trans.Trace(ipath)
+
+	out := struct {
+		Path   string           `json:"path"`
+		Trace  []xlat.TraceStep `json:"trace"`
+		Module *moduleJSON      `json:"module,omitempty"`
+		Repo   *repoJSON        `json:"repo,omitempty"`
+	}{Path: ipath, Trace: trace}
+
+	if mod != nil {
+		out.Module = &moduleJSON{Path: mod.Path(), Dir: mod.Dir(), MovedTo: mod.MovedTo()}
+		out.Repo = newRepoJSON(mod.Repo)
+	}
+
+	return writeJSON(w, out)
+}
+
+func (s *Server) adminOwners(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, s.trans.Owners())
+}
+
+// adminResync refreshes a single repo (?repo=ID or ?repo=owner/name), all
+// repos for one installation (?owner=login or ?owner=ID) or, if neither is
+// given, runs a full discovery. The resulting Diff is returned.
+func (s *Server) adminResync(w http.ResponseWriter, r *http.Request) error {
+	var (
+		d   *xlat.Diff
+		err error
+	)
+
+	repo, owner := r.FormValue("repo"), r.FormValue("owner")
+
+	switch {
+	case repo != "" && owner != "":
+		return httperr.LogErrorf("only one of repo or owner may be specified").WithOptions(httperr.Status(http.StatusBadRequest))
+
+	case repo != "":
+		log.Infof("Admin resync of repo %q", repo)
+		d, err = s.trans.RefreshRepo(r.Context(), repo)
+
+	case owner != "":
+		log.Infof("Admin resync of owner %q", owner)
+		d, err = s.trans.RefreshOwner(r.Context(), owner)
+
+	default:
+		log.Info("Admin resync of all repos")
+		d, err = s.trans.Discover(r.Context())
+	}
+
+	if err != nil {
+		return httperr.LogErrorf("resync failed: %v", err)
+	}
+
+	return writeJSON(w, d)
+}
+
+func (s *Server) adminDeliveries(w http.ResponseWriter, r *http.Request) error {
+	var limit int
+
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			return httperr.LogErrorf("invalid limit %q: %v", l, err).WithOptions(httperr.Status(http.StatusBadRequest))
+		}
+	}
+
+	return writeJSON(w, s.dlog.list(limit))
+}
+
+func (s *Server) adminDeadLetters(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, s.queue.deadLetters())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return httperr.LogErrorf("encoding JSON response: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// delivery is the record of a single webhook delivery, as identified by
+// its X-GitHub-Delivery header.
+type delivery struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	Action     string    `json:"action,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Received   time.Time `json:"received"`
+	Duplicates int       `json:"duplicates,omitempty"`
+}
+
+// deliveryLog holds the most recent webhook deliveries, up to max, in the
+// order they were received. It's used both to skip redelivered events that
+// have already been processed and to report recent activity.
+type deliveryLog struct {
+	mu   sync.Mutex
+	max  int
+	recs []*delivery
+	byID map[string]*delivery
+}
+
+func newDeliveryLog(max int) *deliveryLog {
+	return &deliveryLog{max: max, byID: make(map[string]*delivery)}
+}
+
+// begin records the start of processing for the given delivery. If a
+// delivery with the same ID is pending, awaiting a retry or has already
+// been processed successfully, its duplicate count is bumped and begin
+// returns nil. Deliveries that previously failed are recorded anew so they
+// may be retried.
+func (dl *deliveryLog) begin(id, event, action string) *delivery {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if d := dl.byID[id]; d != nil {
+		switch d.Outcome {
+		case "pending", "retrying", "ok", "skipped":
+			d.Duplicates++
+			return nil
+		}
+		dl.drop(d)
+	}
+
+	d := &delivery{ID: id, Event: event, Action: action, Outcome: "pending", Received: time.Now()}
+	dl.add(d)
+
+	return d
+}
+
+// finish sets the outcome of a delivery returned by begin.
+func (dl *deliveryLog) finish(d *delivery, outcome string) {
+	dl.mu.Lock()
+	d.Outcome = outcome
+	dl.mu.Unlock()
+}
+
+// list returns copies of up to limit of the most recent deliveries, newest
+// first. A limit <= 0 returns all of them.
+func (dl *deliveryLog) list(limit int) []delivery {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if limit <= 0 || limit > len(dl.recs) {
+		limit = len(dl.recs)
+	}
+
+	out := make([]delivery, 0, limit)
+	for i := len(dl.recs) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, *dl.recs[i])
+	}
+
+	return out
+}
+
+// add appends d to the log, evicting the oldest entries beyond dl.max.
+// Deliveries without an ID are logged but cannot be deduplicated.
+func (dl *deliveryLog) add(d *delivery) {
+	if dl.max <= 0 {
+		return
+	}
+
+	dl.recs = append(dl.recs, d)
+	if d.ID != "" {
+		dl.byID[d.ID] = d
+	}
+
+	for len(dl.recs) > dl.max {
+		if old := dl.recs[0]; dl.byID[old.ID] == old {
+			delete(dl.byID, old.ID)
+		}
+		dl.recs[0] = nil
+		dl.recs = dl.recs[1:]
+	}
+}
+
+func (dl *deliveryLog) drop(d *delivery) {
+	delete(dl.byID, d.ID)
+
+	for i, r := range dl.recs {
+		if r == d {
+			dl.recs = append(dl.recs[:i], dl.recs[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthz reports that the process is alive and able to serve requests.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+	return nil
+}
+
+type readyCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// readyz reports whether this server should receive traffic: initial
+// discovery must have completed, the last resync must not be older than
+// the configured threshold and Github must be accepting our credentials.
+// It responds with 503 if any check fails.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) error {
+	last := s.trans.LastSync()
+
+	checks := []*readyCheck{
+		{Name: "discovery", OK: !last.IsZero()},
+		{Name: "freshness", OK: true},
+		{Name: "credentials", OK: s.trans.CredentialsValid()},
+	}
+
+	if !last.IsZero() {
+		age := time.Since(last).Round(time.Second)
+		checks[1].Detail = fmt.Sprintf("last resync %v ago", age)
+		if s.Resync > 0 && s.StaleAfter > 0 && age > s.StaleAfter {
+			checks[1].OK = false
+		}
+	}
+
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+
+	if !ready {
+		w.Header().Set("Retry-After", retryAfter)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	return writeJSON(w, struct {
+		Ready  bool          `json:"ready"`
+		Checks []*readyCheck `json:"checks"`
+	}{ready, checks})
+}
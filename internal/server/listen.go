@@ -0,0 +1,248 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"strconv"
+	"strings"
+
+	"toolman.org/base/log/v2"
+
+	"toolman.org/svc/build/go/gogetter/internal/config"
+)
+
+// endpoint is a single listener along with the means of serving requests
+// arriving on it; HTTP(S) if hsrv is non-nil, otherwise FastCGI.
+type endpoint struct {
+	kind string
+	desc string
+	lis  net.Listener
+	hsrv *http.Server
+	sock string // Unix socket file to remove on close (if any)
+}
+
+func (ep *endpoint) serve(s *Server, hndlr http.Handler) error {
+	if ep.hsrv != nil {
+		return ep.hsrv.Serve(ep.lis)
+	}
+
+	return fcgi.Serve(ep.lis, s.trackRequests(hndlr))
+}
+
+func (ep *endpoint) close() {
+	ep.lis.Close()
+
+	if ep.sock == "" {
+		return
+	}
+
+	if err := os.Remove(ep.sock); err != nil && !os.IsNotExist(err) {
+		log.Warningf("Failed removing socket %q: %v", ep.sock, err)
+	}
+}
+
+func closeEndpoints(eps []*endpoint) {
+	for _, ep := range eps {
+		ep.close()
+	}
+}
+
+func httpEndpoint(lis net.Listener, hndlr http.Handler) *endpoint {
+	return &endpoint{kind: "HTTP", desc: lis.Addr().String(), lis: lis, hsrv: &http.Server{Handler: hndlr}}
+}
+
+func fcgiEndpoint(lis net.Listener) *endpoint {
+	return &endpoint{kind: "FCGI", desc: lis.Addr().String(), lis: lis}
+}
+
+// endpoints opens listeners for all configured endpoints: HTTP on --port
+// (or a redirect to HTTPS if --tls-port is also set), HTTPS on --tls-port,
+// FastCGI on the --socket unix socket (or, if --socket=systemd, each of
+// the sockets passed in by systemd) and each of the --listen addresses.
+// Only --port redirects; other plain HTTP endpoints may well sit behind a
+// TLS-terminating proxy. If any listener fails, those already opened are
+// closed.
+func (s *Server) endpoints(hndlr http.Handler) (eps []*endpoint, err error) {
+	defer func() {
+		if err != nil {
+			closeEndpoints(eps)
+			eps = nil
+		}
+	}()
+
+	ceps, err := s.Endpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case s.Socket == "":
+	case strings.ToLower(s.Socket) == "systemd":
+		ceps = append([]*config.Endpoint{{Proto: "systemd"}}, ceps...)
+	default:
+		ceps = append([]*config.Endpoint{{Proto: "fcgi", Network: "unix", Addr: s.Socket}}, ceps...)
+	}
+
+	phndlr := hndlr
+
+	if s.TLSPort != 0 {
+		tc, mgr, err := s.tlsConfig()
+		if err != nil {
+			return eps, err
+		}
+
+		lis, err := net.Listen("tcp", tcpAddr(s.TLSPort))
+		if err != nil {
+			return eps, err
+		}
+
+		ep := httpEndpoint(tls.NewListener(lis, tc), hndlr)
+		ep.kind, ep.hsrv.TLSConfig = "HTTPS", tc
+		eps = append(eps, ep)
+
+		phndlr = s.redirect(hndlr, mgr)
+	}
+
+	if s.Port != 0 {
+		ep, err := listen(&config.Endpoint{Proto: "http", Network: "tcp", Addr: s.addr()}, phndlr)
+		if err != nil {
+			return eps, err
+		}
+
+		eps = append(eps, ep)
+	}
+
+	for _, cep := range ceps {
+		if cep.Proto == "systemd" {
+			seps, err := systemdEndpoints(hndlr)
+			eps = append(eps, seps...)
+			if err != nil {
+				return eps, err
+			}
+			continue
+		}
+
+		ep, err := listen(cep, hndlr)
+		if err != nil {
+			return eps, err
+		}
+
+		eps = append(eps, ep)
+	}
+
+	return eps, nil
+}
+
+// listen opens a listener for cep, which must not be a systemd endpoint.
+func listen(cep *config.Endpoint, hndlr http.Handler) (*endpoint, error) {
+	lis, err := net.Listen(cep.Network, cep.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var ep *endpoint
+	if cep.Proto == "http" {
+		ep = httpEndpoint(lis, hndlr)
+	} else {
+		ep = fcgiEndpoint(lis)
+	}
+
+	if cep.Network == "unix" {
+		ep.sock = cep.Addr
+	}
+
+	return ep, nil
+}
+
+// systemdEndpoints returns an endpoint for each socket passed in by
+// systemd. Sockets are served as FastCGI unless their name (as given
+// by FileDescriptorName= in the socket unit) is "http" or starts with
+// "http-", in which case they're served as plain HTTP using hndlr.
+func systemdEndpoints(hndlr http.Handler) ([]*endpoint, error) {
+	lpid, lfds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if lpid == "" || lfds == "" {
+		return nil, errors.New("systemd socket not found")
+	}
+
+	pid := os.Getpid()
+
+	if i, err := strconv.Atoi(lpid); err != nil || i != pid {
+		if err == nil {
+			err = fmt.Errorf("systemd socket pid mismatch: got %d; wanted %d", i, pid)
+		}
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(lfds)
+	if err != nil || n < 1 {
+		if err == nil {
+			err = fmt.Errorf("systemd socket count invalid: %d", n)
+		}
+		return nil, err
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var eps []*endpoint
+
+	for i := 0; i < n; i++ {
+		var name string
+		if i < len(names) {
+			name = names[i]
+		}
+
+		// File descriptors passed by systemd start at 3.
+		lis, err := net.FileListener(os.NewFile(uintptr(3+i), "systemd:"+name))
+		if err != nil {
+			return eps, fmt.Errorf("systemd socket %d (%q): %v", i, name, err)
+		}
+
+		var ep *endpoint
+		if name == "http" || strings.HasPrefix(name, "http-") {
+			ep = httpEndpoint(lis, hndlr)
+		} else {
+			ep = fcgiEndpoint(lis)
+		}
+
+		if name != "" {
+			ep.desc = fmt.Sprintf("systemd:%s (%s)", name, ep.desc)
+		}
+
+		eps = append(eps, ep)
+	}
+
+	return eps, nil
+}
+
+func (s *Server) addr() string {
+	return tcpAddr(s.Port)
+}
+
+func tcpAddr(port int64) string {
+	return (&net.TCPAddr{Port: int(port)}).String()
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	goGetRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gogetter",
+		Name:      "goget_requests_total",
+		Help:      "Number of go-get requests by result (hit, miss or error) and nearest configured prefix.",
+	}, []string{"result", "prefix"})
+
+	webhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gogetter",
+		Name:      "webhook_events_total",
+		Help:      "Number of Github webhook deliveries by event type, action and outcome.",
+	}, []string{"event", "action", "outcome"})
+
+	webhookQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gogetter",
+		Name:      "webhook_queue_depth",
+		Help:      "Number of webhook events queued or being processed.",
+	})
+)
+
+// unknownEvent is the event label used for webhook deliveries that fail
+// validation or parsing, since the X-GitHub-Event header is supplied by
+// the client (and isn't covered by the payload signature).
+const unknownEvent = "unknown"
+
+func init() {
+	prometheus.MustRegister(goGetRequests, webhookEvents, webhookQueueDepth)
+}
+
+// eventAction returns the action of a parsed webhook event, for those
+// event types that have one.
+func eventAction(event interface{}) string {
+	if a, ok := event.(interface{ GetAction() string }); ok {
+		return a.GetAction()
+	}
+	return ""
+}
+
+func prefixLabel(pfx string) string {
+	if pfx == "" {
+		return "none"
+	}
+	return pfx
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"toolman.org/base/log/v2"
+	"toolman.org/net/http/httperr"
+
+	"toolman.org/svc/build/go/gogetter/internal/xlat"
+)
+
+// redirectDelay is the number of seconds a browser lingers on a package's
+// landing page before being redirected to the repository's web page.
+const redirectDelay = 5
+
+var pages = template.Must(template.New("pages").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{- with .Meta}}
+{{.}}
+{{- end}}
+{{- if .Redirect}}
+<meta http-equiv="refresh" content="{{.Delay}}; url={{.Redirect}}">
+{{- end}}
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 50em; color: #222; }
+code, pre { background: #f4f4f4; padding: 0.1em 0.3em; }
+dt { font-weight: bold; margin-top: 0.8em; }
+table { border-collapse: collapse; margin-top: 1em; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; vertical-align: top; }
+.muted { color: #777; }
+.deprecated { background: #fff3cd; border: 1px solid #e0c36c; padding: 0.5em 1em; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "deprecated"}}
+{{- with .Module.MovedTo}}
+<p class="deprecated"><strong>Deprecated:</strong> this import path has moved to
+<code>{{.}}</code> and will stop being served on {{$.Module.Expires.Format "2006-01-02"}}.
+Please update your imports.</p>
+{{- end}}
+{{- end}}
+
+{{define "landing"}}{{template "header" .}}
+<h1><code>{{.ImportPath}}</code></h1>
+{{- template "deprecated" .}}
+<pre>go get {{.ImportPath}}</pre>
+<dl>
+<dt>Module</dt>
+<dd><code>{{.Module.Path}}</code></dd>
+<dt>Repository</dt>
+<dd><a href="{{.Module.HTMLURL}}">{{.Module.FullName}}</a>{{if .Module.Private}} <span class="muted">(private)</span>{{end}}{{if .Module.Archived}} <span class="muted">(archived)</span>{{end}}</dd>
+<dt>Clone</dt>
+<dd><code>{{.Module.PublicURL}}</code></dd>
+<dd><code>{{.Module.PrivateURL}}</code></dd>
+<dt>Documentation</dt>
+<dd><a href="{{.DocURL}}">{{.DocURL}}</a></dd>
+</dl>
+<p class="muted">Redirecting to <a href="{{.Redirect}}">{{.Redirect}}</a> in {{.Delay}} seconds&hellip;</p>
+{{template "footer" .}}{{end}}
+
+{{define "goget"}}{{template "header" .}}
+<p><code>go get {{.ImportPath}}</code></p>
+{{- template "deprecated" .}}
+<p>Source: <a href="{{.Module.HTMLURL}}">{{.Module.HTMLURL}}</a></p>
+{{template "footer" .}}{{end}}
+
+{{define "index"}}{{template "header" .}}
+<h1>Packages under <code>{{.ImportPath}}</code></h1>
+<form method="get">
+<select name="owner">
+<option value="">All owners</option>
+{{- range .Owners}}
+<option value="{{.}}"{{if eq . $.Owner}} selected{{end}}>{{.}}</option>
+{{- end}}
+</select>
+<input type="search" name="q" value="{{.Query}}" placeholder="Search packages">
+<input type="submit" value="Filter">
+</form>
+<table>
+<tr><th>Package</th><th>Repository</th><th>Visibility</th><th>Description</th><th>Last Updated</th></tr>
+{{- range .Modules}}
+<tr>
+<td><a href="//{{.Path}}"><code>{{.Path}}</code></a></td>
+<td><a href="{{.HTMLURL}}">{{.FullName}}</a></td>
+<td>{{if .Private}}private{{else}}public{{end}}{{if .Archived}} <span class="muted">(archived)</span>{{end}}</td>
+<td>{{.Description}}</td>
+<td class="muted">{{if not .Updated.IsZero}}{{.Updated.Format "2006-01-02"}}{{end}}</td>
+</tr>
+{{- else}}
+<tr><td colspan="5" class="muted">No matching packages</td></tr>
+{{- end}}
+</table>
+{{template "footer" .}}{{end}}
+
+{{define "notfound"}}{{template "header" .}}
+<h1>Not Found</h1>
+<p>No Go package is served for <code>{{.ImportPath}}</code>.</p>
+{{- with .Prefix}}
+<p>Packages under <code>{{.}}</code> are served here, but no repository
+provides this import path. Check that the repository exists, contains Go
+code and is accessible to this service.</p>
+{{- end}}
+{{template "footer" .}}{{end}}
+`))
+
+type pageData struct {
+	Title      string
+	ImportPath string
+	Module     *xlat.Module
+	Meta       template.HTML // go-import and go-source tags
+	Prefix     string        // Nearest configured prefix (for 404 hints)
+
+	// Index page fields
+	Modules  []*xlat.Module
+	Owners   []string
+	Owner    string
+	Query    string
+	DocURL   string
+	Redirect string
+	Delay    int
+}
+
+// landing renders a human friendly page for browser requests (i.e. those
+// without ?go-get=1) that links to the package's docs and repository.
+func (s *Server) landing(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return httperr.LogErrorf("bad request method: %s", r.Method).WithOptions(httperr.Status(http.StatusMethodNotAllowed))
+	}
+
+	if !s.trans.Ready() {
+		w.Header().Set("Retry-After", retryAfter)
+		return httperr.LogErrorf("translation table not yet available").WithOptions(httperr.Status(http.StatusServiceUnavailable))
+	}
+
+	ipath := path.Join(r.Host, r.URL.Path)
+
+	for _, p := range s.trans.Prefixes() {
+		if ipath == p {
+			return s.index(w, r, p)
+		}
+	}
+
+	mod := s.trans.Lookup(ipath)
+	if mod == nil {
+		return s.renderPage(w, http.StatusNotFound, "notfound", &pageData{
+			Title:      "Not Found",
+			ImportPath: ipath,
+			Prefix:     s.trans.NearestPrefix(ipath),
+		})
+	}
+
+	return s.renderPage(w, http.StatusOK, "landing", &pageData{
+		Title:      ipath,
+		ImportPath: ipath,
+		Module:     mod,
+		DocURL:     "https://pkg.go.dev/" + ipath,
+		Redirect:   mod.HTMLURL(),
+		Delay:      redirectDelay,
+	})
+}
+
+// index renders a list of all modules served under the package prefix pfx,
+// optionally filtered by repository owner and/or a search query.
+func (s *Server) index(w http.ResponseWriter, r *http.Request, pfx string) error {
+	data := &pageData{
+		Title:      pfx,
+		ImportPath: pfx,
+		Owner:      r.FormValue("owner"),
+		Query:      strings.TrimSpace(r.FormValue("q")),
+	}
+
+	q := strings.ToLower(data.Query)
+	owners := make(map[string]bool)
+
+	for _, m := range s.trans.Modules(pfx) {
+		owners[m.Owner()] = true
+
+		if data.Owner != "" && m.Owner() != data.Owner {
+			continue
+		}
+
+		if q != "" && !matchModule(m, q) {
+			continue
+		}
+
+		data.Modules = append(data.Modules, m)
+	}
+
+	for o := range owners {
+		data.Owners = append(data.Owners, o)
+	}
+	sort.Strings(data.Owners)
+
+	return s.renderPage(w, http.StatusOK, "index", data)
+}
+
+// matchModule returns true if the lowercase query string q appears in m's
+// path, repository name or description.
+func matchModule(m *xlat.Module, q string) bool {
+	for _, s := range []string{m.Path(), m.FullName(), m.Description()} {
+		if strings.Contains(strings.ToLower(s), q) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) renderPage(w http.ResponseWriter, status int, name string, data *pageData) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		// Too late to send an error status; just log it.
+		log.Errorf("Rendering %s page: %v", name, err)
+	}
+
+	return nil
+}
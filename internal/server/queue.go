@@ -0,0 +1,299 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v27/github"
+	"toolman.org/base/log/v2"
+
+	"toolman.org/svc/build/go/gogetter/internal/xlat"
+)
+
+const (
+	minHookRetry   = 2 * time.Second
+	maxHookRetry   = 2 * time.Minute
+	maxDeadLetters = 100
+)
+
+// job is a parsed webhook event awaiting processing.
+type job struct {
+	d      *delivery
+	event  interface{}
+	enam   string
+	action string
+	key    string // Events with the same key are processed in order
+	tries  int
+}
+
+// deadLetter describes a job that failed on every attempt.
+type deadLetter struct {
+	Delivery string    `json:"delivery"`
+	Event    string    `json:"event"`
+	Action   string    `json:"action,omitempty"`
+	Key      string    `json:"key"`
+	Tries    int       `json:"tries"`
+	Error    string    `json:"error"`
+	Failed   time.Time `json:"failed"`
+}
+
+// eventQueue processes webhook events asynchronously using a fixed number
+// of workers. Jobs sharing a key (i.e. concerning the same repository or
+// installation) are kept in a lane and processed strictly in order; a
+// failed job is retried with exponential backoff, holding up the rest of
+// its lane, until it has been tried maxTries times, after which it's moved
+// to the dead-letter list.
+type eventQueue struct {
+	handle func(context.Context, *job) error
+	report func(*job, string)
+
+	max, maxTries int
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	lanes  map[string][]*job
+	runq   []string // Keys of lanes whose head is ready to run
+	size   int
+	closed bool
+	dead   []*deadLetter
+}
+
+func newEventQueue(max, workers, maxTries int, handle func(context.Context, *job) error, report func(*job, string)) *eventQueue {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if maxTries < 1 {
+		maxTries = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &eventQueue{
+		handle:   handle,
+		report:   report,
+		max:      max,
+		maxTries: maxTries,
+		ctx:      ctx,
+		cancel:   cancel,
+		lanes:    make(map[string][]*job),
+	}
+
+	q.cond = sync.NewCond(&q.mu)
+
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+
+	return q
+}
+
+// push adds j to the end of its lane. It returns false if the queue is
+// full or has been stopped.
+func (q *eventQueue) push(j *job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || (q.max > 0 && q.size >= q.max) {
+		return false
+	}
+
+	q.size++
+	webhookQueueDepth.Set(float64(q.size))
+
+	lane := q.lanes[j.key]
+	q.lanes[j.key] = append(lane, j)
+
+	if len(lane) == 0 {
+		q.ready(j.key)
+	}
+
+	return true
+}
+
+// ready marks the head of lane key as runnable; q.mu must be held.
+func (q *eventQueue) ready(key string) {
+	q.runq = append(q.runq, key)
+	q.cond.Signal()
+}
+
+func (q *eventQueue) next() (string, *job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.runq) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if q.closed {
+		return "", nil, false
+	}
+
+	key := q.runq[0]
+	q.runq = q.runq[1:]
+
+	return key, q.lanes[key][0], true
+}
+
+func (q *eventQueue) worker() {
+	defer q.wg.Done()
+
+	for {
+		key, j, ok := q.next()
+		if !ok {
+			return
+		}
+
+		q.done(key, j, q.handle(q.ctx, j))
+	}
+}
+
+// done handles the result of an attempt to process j, either scheduling
+// a retry or removing it from its lane and readying the next job.
+func (q *eventQueue) done(key string, j *job, err error) {
+	j.tries++
+
+	if err != nil && j.tries < q.maxTries && q.ctx.Err() == nil {
+		delay := minHookRetry << uint(j.tries-1)
+		if delay > maxHookRetry {
+			delay = maxHookRetry
+		}
+
+		log.Warningf("Event %s (delivery %s) failed (attempt %d of %d; retrying in %v): %v",
+			j.enam, j.d.ID, j.tries, q.maxTries, delay, err)
+		q.report(j, "retrying")
+
+		time.AfterFunc(delay, func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if !q.closed {
+				q.ready(key)
+			}
+		})
+
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Event %s (delivery %s) failed after %d attempts: %v", j.enam, j.d.ID, j.tries, err)
+	}
+
+	q.report(j, xlat.Outcome(err))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err != nil {
+		q.dead = append(q.dead, &deadLetter{
+			Delivery: j.d.ID,
+			Event:    j.enam,
+			Action:   j.action,
+			Key:      key,
+			Tries:    j.tries,
+			Error:    err.Error(),
+			Failed:   time.Now(),
+		})
+
+		if n := len(q.dead) - maxDeadLetters; n > 0 {
+			q.dead = append(q.dead[:0:0], q.dead[n:]...)
+		}
+	}
+
+	q.size--
+	webhookQueueDepth.Set(float64(q.size))
+
+	if lane := q.lanes[key][1:]; len(lane) == 0 {
+		delete(q.lanes, key)
+	} else {
+		q.lanes[key] = lane
+		q.ready(key)
+	}
+}
+
+// deadLetters returns copies of the dead-letter list, newest first.
+func (q *eventQueue) deadLetters() []deadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	out := make([]deadLetter, 0, len(q.dead))
+	for i := len(q.dead) - 1; i >= 0; i-- {
+		out = append(out, *q.dead[i])
+	}
+
+	return out
+}
+
+// stop prevents any further jobs from being queued or started and then
+// waits for those in progress to complete or for ctx to expire, whichever
+// comes first; in the latter case, in-progress jobs are cancelled. Jobs
+// still queued are abandoned.
+func (q *eventQueue) stop(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	size := q.size
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	if size > 0 {
+		log.Warningf("Webhook queue stopping with %d unfinished events", size)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(finished)
+	}()
+
+	defer q.cancel()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// eventKey returns the ordering key for a webhook event: the ID of the
+// repository it concerns or, failing that, the installation ID.
+func eventKey(event interface{}) string {
+	if e, ok := event.(*github.PushEvent); ok {
+		return fmt.Sprintf("repo:%d", e.GetRepo().GetID())
+	}
+
+	if e, ok := event.(interface{ GetRepo() *github.Repository }); ok && e.GetRepo() != nil {
+		return fmt.Sprintf("repo:%d", e.GetRepo().GetID())
+	}
+
+	if e, ok := event.(interface{ GetInstallation() *github.Installation }); ok && e.GetInstallation() != nil {
+		return fmt.Sprintf("installation:%d", e.GetInstallation().GetID())
+	}
+
+	return fmt.Sprintf("%T", event)
+}
@@ -0,0 +1,277 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testQueue records the order in which jobs are handled and reported.
+type testQueue struct {
+	mu      sync.Mutex
+	handled []string
+	reports []string
+	settled chan *job
+}
+
+func newTestQueue() *testQueue {
+	return &testQueue{settled: make(chan *job, 1000)}
+}
+
+func (tq *testQueue) report(j *job, outcome string) {
+	tq.mu.Lock()
+	tq.reports = append(tq.reports, j.d.ID+":"+outcome)
+	tq.mu.Unlock()
+
+	if outcome != "retrying" {
+		tq.settled <- j
+	}
+}
+
+func (tq *testQueue) wait(t *testing.T, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-tq.settled:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for job %d of %d", i+1, n)
+		}
+	}
+}
+
+func testJob(id, key string) *job {
+	return &job{d: &delivery{ID: id}, enam: "push", key: key}
+}
+
+func TestQueueOrder(t *testing.T) {
+	tq := newTestQueue()
+
+	handle := func(ctx context.Context, j *job) error {
+		time.Sleep(time.Millisecond)
+		tq.mu.Lock()
+		tq.handled = append(tq.handled, j.d.ID)
+		tq.mu.Unlock()
+		return nil
+	}
+
+	q := newEventQueue(0, 4, 1, handle, tq.report)
+	defer q.stop(context.Background())
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		for _, k := range []string{"a", "b", "c"} {
+			if !q.push(testJob(fmt.Sprintf("%s%02d", k, i), k)) {
+				t.Fatalf("push %s%d failed", k, i)
+			}
+		}
+	}
+
+	tq.wait(t, 3*n)
+
+	last := map[string]string{}
+	for _, id := range tq.handled {
+		k := id[:1]
+		if id < last[k] {
+			t.Errorf("job %s handled after %s", id, last[k])
+		}
+		last[k] = id
+	}
+
+	if len(tq.handled) != 3*n {
+		t.Errorf("handled %d jobs; wanted %d", len(tq.handled), 3*n)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	handle := func(ctx context.Context, j *job) error {
+		<-release
+		return nil
+	}
+
+	tq := newTestQueue()
+	q := newEventQueue(2, 1, 1, handle, tq.report)
+	defer q.stop(context.Background())
+
+	for i, want := range []bool{true, true, false} {
+		if got := q.push(testJob(fmt.Sprint(i), "k")); got != want {
+			t.Errorf("push #%d: got %v; wanted %v", i, got, want)
+		}
+	}
+
+	close(release)
+	tq.wait(t, 2)
+
+	if !q.push(testJob("3", "k")) {
+		t.Error("push after drain failed")
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	tq := newTestQueue()
+
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+
+	handle := func(ctx context.Context, j *job) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		tq.mu.Lock()
+		tq.handled = append(tq.handled, j.d.ID)
+		tq.mu.Unlock()
+
+		if j.d.ID == "first" {
+			times = append(times, time.Now())
+			if j.tries == 0 {
+				return errors.New("transient")
+			}
+		}
+
+		return nil
+	}
+
+	q := newEventQueue(0, 2, 3, handle, tq.report)
+	defer q.stop(context.Background())
+
+	q.push(testJob("first", "k"))
+	q.push(testJob("second", "k"))
+
+	tq.wait(t, 2)
+
+	want := []string{"first", "first", "second"}
+	if fmt.Sprint(tq.handled) != fmt.Sprint(want) {
+		t.Errorf("handled %v; wanted %v", tq.handled, want)
+	}
+
+	want = []string{"first:retrying", "first:ok", "second:ok"}
+	if fmt.Sprint(tq.reports) != fmt.Sprint(want) {
+		t.Errorf("reports %v; wanted %v", tq.reports, want)
+	}
+
+	if len(times) == 2 {
+		if d := times[1].Sub(times[0]); d < minHookRetry {
+			t.Errorf("retried after %v; wanted at least %v", d, minHookRetry)
+		}
+	}
+
+	if dl := q.deadLetters(); len(dl) != 0 {
+		t.Errorf("unexpected dead letters: %v", dl)
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	tq := newTestQueue()
+
+	handle := func(ctx context.Context, j *job) error {
+		return errors.New("permanent")
+	}
+
+	q := newEventQueue(0, 4, 1, handle, tq.report)
+	defer q.stop(context.Background())
+
+	const n = maxDeadLetters + 10
+	for i := 0; i < n; i++ {
+		q.push(testJob(fmt.Sprintf("%03d", i), "k"))
+	}
+
+	tq.wait(t, n)
+
+	dl := q.deadLetters()
+	if len(dl) != maxDeadLetters {
+		t.Fatalf("got %d dead letters; wanted %d", len(dl), maxDeadLetters)
+	}
+
+	if got, want := dl[0].Delivery, fmt.Sprintf("%03d", n-1); got != want {
+		t.Errorf("newest dead letter is %q; wanted %q", got, want)
+	}
+
+	if got, want := dl[len(dl)-1].Delivery, fmt.Sprintf("%03d", n-maxDeadLetters); got != want {
+		t.Errorf("oldest dead letter is %q; wanted %q", got, want)
+	}
+
+	if dl[0].Tries != 1 || dl[0].Error != "permanent" {
+		t.Errorf("bad dead letter: %+v", dl[0])
+	}
+}
+
+func TestQueueStop(t *testing.T) {
+	tq := newTestQueue()
+	handle := func(ctx context.Context, j *job) error { return nil }
+
+	q := newEventQueue(0, 1, 1, handle, tq.report)
+
+	q.push(testJob("1", "k"))
+	tq.wait(t, 1)
+
+	if err := q.stop(context.Background()); err != nil {
+		t.Errorf("stop: %v", err)
+	}
+
+	if q.push(testJob("2", "k")) {
+		t.Error("push after stop succeeded")
+	}
+}
+
+func TestQueueStopTimeout(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	handle := func(ctx context.Context, j *job) error {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}
+
+	tq := newTestQueue()
+	q := newEventQueue(0, 1, 3, handle, tq.report)
+
+	q.push(testJob("1", "k"))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := q.stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("stop returned %v; wanted %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-progress job not cancelled")
+	}
+
+	// A cancelled job isn't retried.
+	tq.wait(t, 1)
+	if want := []string{"1:error"}; fmt.Sprint(tq.reports) != fmt.Sprint(want) {
+		t.Errorf("reports %v; wanted %v", tq.reports, want)
+	}
+}
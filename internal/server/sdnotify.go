@@ -0,0 +1,91 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"toolman.org/base/log/v2"
+)
+
+// sdNotify sends state to systemd's notification socket. It does nothing
+// if we're not running under systemd (i.e. NOTIFY_SOCKET is unset).
+func sdNotify(state string) {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return
+	}
+
+	// A leading '@' denotes a socket in the abstract namespace.
+	if sock[0] == '@' {
+		sock = "\x00" + sock[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		log.Warningf("sd_notify %q: %v", state, err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Warningf("sd_notify %q: %v", state, err)
+	}
+}
+
+// watchdogInterval returns how often systemd expects a WATCHDOG=1
+// notification, or zero if the watchdog is not enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdog pings systemd's watchdog at half the requested interval until
+// quit is closed.
+func watchdog(quit <-chan struct{}) {
+	iv := watchdogInterval()
+	if iv == 0 {
+		return
+	}
+
+	tick := time.NewTicker(iv / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-tick.C:
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
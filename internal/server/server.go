@@ -21,19 +21,18 @@
 package server
 
 import (
+	"bytes"
 	"context"
-	"errors"
 	"fmt"
-	"net"
+	"html/template"
 	"net/http"
-	"net/http/fcgi"
-	"os"
 	"path"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
 
-	"github.com/google/go-github/v25/github"
+	"github.com/google/go-github/v27/github"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"toolman.org/base/log/v2"
 	"toolman.org/net/http/httperr"
@@ -45,99 +44,265 @@ import (
 type Server struct {
 	trans *xlat.Translator
 	*config.Config
+
+	mu    sync.Mutex
+	stop  bool           // Set once Shutdown has been called
+	eps   []*endpoint    // Active endpoints
+	freqs sync.WaitGroup // In-flight FastCGI requests (only added to with mu held)
+	dlog  *deliveryLog   // Recent webhook deliveries
+	queue *eventQueue    // Webhook events awaiting processing
+	done  chan struct{}  // Closed once Shutdown has completed
 }
 
 func New(cfg *config.Config, translator *xlat.Translator) *Server {
-	return &Server{trans: translator, Config: cfg}
+	s := &Server{
+		trans:  translator,
+		Config: cfg,
+		dlog:   newDeliveryLog(cfg.DeliveryLog),
+		done:   make(chan struct{}),
+	}
+
+	s.queue = newEventQueue(cfg.HookQueue, cfg.HookWorkers, cfg.HookTries, s.runJob, s.reportJob)
+
+	return s
 }
 
-// TODO: ListenAndServe should accept a context for shutdown
-func (s *Server) ListenAndServe() error {
-	r := mux.NewRouter()
+// ShutdownTimeout is how long in-flight requests are given to complete
+// once the server starts shutting down.
+const ShutdownTimeout = 5 * time.Second
+
+// ListenAndServe serves requests on all configured endpoints until Shutdown
+// is called or ctx is cancelled; in the latter case, in-flight requests are
+// given up to ShutdownTimeout to complete. When the server is stopped,
+// ListenAndServe does not return until Shutdown has finished draining
+// requests. If any endpoint fails, all are closed and its error returned.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	r := s.router()
+
+	quit := make(chan struct{})
+	defer close(quit)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// ctx is already done, so draining needs a context of its own.
+			sctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+			defer cancel()
+
+			if err := s.Shutdown(sctx); err != nil {
+				log.Errorf("Server shutdown: %v", err)
+			}
+		case <-quit:
+		}
+	}()
 
-	r.Queries("go-get", "1").Handler(httperr.Handler(s.reroute))
-	r.Handle("/hook", httperr.Handler(s.receiveHook))
+	go watchdog(quit)
 
-	if s.Socket != "" {
-		return s.fcgiServe(r)
+	eps, err := s.endpoints(r)
+	if err != nil {
+		return err
 	}
 
-	// TODO: Add alternate support for FastCGI over unix-domain sockets.
-	//       (See ~gto/gosrc-redirector/main.go?func=fcgiServe)
-	addr := s.addr()
-	log.Infof("HTTP Server Ready: %s", addr)
-	return http.ListenAndServe(addr, r)
-}
+	if !s.register(eps) {
+		closeEndpoints(eps)
+		return nil
+	}
 
-func (s *Server) Shutdown(ctx context.Context) {
-	log.Warning("Server shutdown not yet implemented")
-}
+	errs := make(chan error, len(eps))
+
+	for _, ep := range eps {
+		log.Infof("%s Server Ready: %s", ep.kind, ep.desc)
+		go func(ep *endpoint) { errs <- ep.serve(s, r) }(ep)
+	}
 
-func (s *Server) fcgiServe(hndlr http.Handler) error {
-	var (
-		lis net.Listener
-		err error
-		del bool
-	)
+	sdNotify("READY=1")
 
-	if strings.ToLower(s.Socket) == "systemd" {
-		lis, err = systemdListener()
-	} else {
-		if lis, err = net.Listen("unix", s.Socket); err == nil {
-			del = true
+	for range eps {
+		err := <-errs
+		if s.stopping() {
+			continue
 		}
-	}
 
-	if err != nil {
+		if err == nil || err == http.ErrServerClosed {
+			continue
+		}
+
+		closeEndpoints(eps)
 		return err
 	}
 
-	defer func() {
-		lis.Close()
-		if del {
-			os.Remove(s.Socket)
-		}
-	}()
+	if s.stopping() {
+		<-s.done
+	}
+
+	return nil
+}
+
+func (s *Server) router() http.Handler {
+	r := mux.NewRouter()
 
-	log.Infof("FCGI Server Ready: %s", s.Socket)
-	return fcgi.Serve(lis, hndlr)
+	r.Queries("go-get", "1").Handler(httperr.Handler(s.reroute))
+	r.Handle("/hook", httperr.Handler(s.receiveHook))
+	r.Handle("/metrics", promhttp.Handler())
+	r.Handle("/healthz", httperr.Handler(s.healthz))
+	r.Handle("/readyz", httperr.Handler(s.readyz))
+	s.addAdminRoutes(r)
+	r.PathPrefix("/").Handler(httperr.Handler(s.landing))
+
+	return r
 }
 
-func systemdListener() (net.Listener, error) {
-	lpid, lfds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
-	if lpid == "" || lfds == "" {
-		return nil, errors.New("systemd socket not found")
+// Shutdown stops the server from accepting new connections and then waits
+// for in-flight requests to complete or for ctx to expire, whichever comes
+// first. FastCGI unix sockets are removed once their listener is closed.
+// Webhook events still being processed are then given the remainder of
+// ctx to complete; any still queued are abandoned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stop {
+		s.mu.Unlock()
+		return nil
 	}
+	s.stop = true
+	eps := s.eps
+	s.mu.Unlock()
 
-	pid := os.Getpid()
+	defer close(s.done)
 
-	if i, err := strconv.Atoi(lpid); err != nil || i != pid {
-		if err == nil {
-			err = fmt.Errorf("systemd socket pid mismatch: got %d; wanted %d", i, pid)
+	log.Info("Server shutting down")
+	sdNotify("STOPPING=1")
+
+	var hsrvs []*http.Server
+	for _, ep := range eps {
+		if ep.hsrv != nil {
+			hsrvs = append(hsrvs, ep.hsrv)
+		} else {
+			ep.close()
 		}
-		return nil, err
 	}
 
-	if i, err := strconv.Atoi(lfds); err != nil || i != 1 {
+	err := shutdownAll(ctx, hsrvs)
+
+	if qerr := s.queue.stop(ctx); err == nil {
+		err = qerr
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.freqs.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
 		if err == nil {
-			err = fmt.Errorf("systemd socket count mismatch: got %d; wanted 1", i)
+			err = ctx.Err()
 		}
-		return nil, err
 	}
 
-	return net.FileListener(os.NewFile(uintptr(3), "systemd:socket"))
+	return err
 }
 
-func (s *Server) addr() string {
-	return (&net.TCPAddr{Port: int(s.Port)}).String()
+// shutdownAll shuts down each of hsrvs concurrently, returning the first
+// error encountered.
+func shutdownAll(ctx context.Context, hsrvs []*http.Server) error {
+	errs := make(chan error, len(hsrvs))
+
+	for _, hsrv := range hsrvs {
+		go func(hsrv *http.Server) { errs <- hsrv.Shutdown(ctx) }(hsrv)
+	}
+
+	var err error
+	for range hsrvs {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
 }
 
+func (s *Server) stopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stop
+}
+
+// register records the active endpoints so that Shutdown may stop them.
+// It returns false if Shutdown has already been called, in which case the
+// caller should not begin serving.
+func (s *Server) register(eps []*endpoint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop {
+		return false
+	}
+
+	s.eps = eps
+	return true
+}
+
+// trackRequests wraps hndlr such that Shutdown can wait for all in-flight
+// FastCGI requests to complete (since package fcgi offers no such facility).
+// Connections accepted before shutdown may still deliver new requests; these
+// are refused so that none can begin once Shutdown is waiting.
+func (s *Server) trackRequests(hndlr http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		if s.stop {
+			s.mu.Unlock()
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		s.freqs.Add(1)
+		s.mu.Unlock()
+
+		defer s.freqs.Done()
+		hndlr.ServeHTTP(w, r)
+	})
+}
+
+// retryAfter is the number of seconds clients are asked to wait before
+// retrying a request that arrived before initial discovery completed.
+const retryAfter = "30"
+
 func (s *Server) reroute(w http.ResponseWriter, r *http.Request) error {
 	log.V(1).Infof("GOGET: host=%q  uri=%q", r.Host, r.URL.Path)
-	if repo := s.trans.Lookup(path.Join(r.Host, r.URL.Path)); repo != nil {
-		repo.WriteImportTags(w)
+
+	ipath := path.Join(r.Host, r.URL.Path)
+	pfx := s.trans.NearestPrefix(ipath)
+
+	if !s.trans.Ready() {
+		goGetRequests.WithLabelValues("error", prefixLabel(pfx)).Inc()
+		w.Header().Set("Retry-After", retryAfter)
+		return httperr.LogErrorf("translation table not yet available").WithOptions(httperr.Status(http.StatusServiceUnavailable))
 	}
-	return nil
+
+	mod := s.trans.Lookup(ipath)
+	if mod == nil {
+		goGetRequests.WithLabelValues("miss", prefixLabel(pfx)).Inc()
+		log.V(1).Infof("GOGET: no module for %q", ipath)
+		return s.renderPage(w, http.StatusNotFound, "notfound", &pageData{
+			Title:      "Not Found",
+			ImportPath: ipath,
+			Prefix:     pfx,
+		})
+	}
+
+	goGetRequests.WithLabelValues("hit", prefixLabel(pfx)).Inc()
+
+	var tags bytes.Buffer
+	mod.WriteImportTags(&tags)
+
+	return s.renderPage(w, http.StatusOK, "goget", &pageData{
+		Title:      ipath,
+		ImportPath: ipath,
+		Module:     mod,
+		Meta:       template.HTML(tags.String()),
+	})
 }
 
 func (s *Server) receiveHook(w http.ResponseWriter, r *http.Request) error {
@@ -145,25 +310,71 @@ func (s *Server) receiveHook(w http.ResponseWriter, r *http.Request) error {
 		return httperr.LogErrorf("bad request method: %s", r.Method).WithOptions(httperr.Status(http.StatusMethodNotAllowed))
 	}
 
-	enam := r.Header.Get("X-GitHub-Event")
+	enam, id := r.Header.Get("X-GitHub-Event"), github.DeliveryID(r)
 
-	if enam == "integration_installation" || enam == "integration_installation_repositories" {
-		log.V(1).Infof("Skipping deprecated event: %s", enam)
-		return nil
-	}
-
-	log.Infof("Recieved event: %s", enam)
+	log.Infof("Recieved event: %s (delivery %s)", enam, id)
 
+	// Until its signature has been checked, nothing about a delivery can
+	// be trusted so it's kept out of the delivery log entirely.
 	payload, err := github.ValidatePayload(r, []byte(s.HookSecret))
 	if err != nil {
+		webhookEvents.WithLabelValues(unknownEvent, "", "invalid").Inc()
 		return httperr.LogErrorf("Failed payload validation: %v", err)
 	}
 
+	if enam == "integration_installation" || enam == "integration_installation_repositories" {
+		log.V(1).Infof("Skipping deprecated event: %s", enam)
+		webhookEvents.WithLabelValues(enam, "", "skipped").Inc()
+		if d := s.dlog.begin(id, enam, ""); d != nil {
+			s.dlog.finish(d, "skipped")
+		}
+		return nil
+	}
+
 	event, err := github.ParseWebHook(github.WebHookType(r), payload)
 	if err != nil {
+		webhookEvents.WithLabelValues(unknownEvent, "", "invalid").Inc()
+		if d := s.dlog.begin(id, enam, ""); d != nil {
+			s.dlog.finish(d, "invalid")
+		}
 		return httperr.LogErrorf("Bad webhook payload: %v", err)
 	}
 
+	action := eventAction(event)
+
+	d := s.dlog.begin(id, enam, action)
+	if d == nil {
+		log.Infof("Skipping duplicate delivery: %s", id)
+		webhookEvents.WithLabelValues(enam, action, "duplicate").Inc()
+		return nil
+	}
+
+	if !s.queue.push(&job{d: d, event: event, enam: enam, action: action, key: eventKey(event)}) {
+		webhookEvents.WithLabelValues(enam, action, "dropped").Inc()
+		s.dlog.finish(d, "dropped")
+		w.Header().Set("Retry-After", retryAfter)
+		return httperr.LogErrorf("webhook queue full; dropping delivery %s", id).WithOptions(httperr.Status(http.StatusServiceUnavailable))
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	return nil
+}
+
+func (s *Server) runJob(ctx context.Context, j *job) error {
+	return s.handleEvent(ctx, j.event)
+}
+
+// reportJob records the outcome of an attempt at processing j.
+func (s *Server) reportJob(j *job, result string) {
+	s.dlog.finish(j.d, result)
+
+	if result != "retrying" {
+		webhookEvents.WithLabelValues(j.enam, j.action, result).Inc()
+	}
+}
+
+func (s *Server) handleEvent(ctx context.Context, event interface{}) error {
 	switch evt := event.(type) {
 	// InstallationEvent is triggered when a GitHub App has been
 	// installed or uninstalled.
@@ -175,6 +386,16 @@ func (s *Server) receiveHook(w http.ResponseWriter, r *http.Request) error {
 			log.Infof("    REPO: id=%d %s", repo.GetID(), repo.GetFullName())
 		}
 
+		switch evt.GetAction() {
+		case "created", "unsuspend":
+			if err := s.trans.AddInstallation(ctx, evt.GetInstallation()); err != nil {
+				return fmt.Errorf("adding installation %d: %v", evt.GetInstallation().GetID(), err)
+			}
+
+		case "deleted", "suspend":
+			s.trans.RemoveInstallation(evt.GetInstallation().GetID())
+		}
+
 	// InstallationRepositoriesEvent is triggered when a repository
 	// is added or removed from an installation.
 	// https://developer.github.com/v3/activity/events/types/#installationrepositoriesevent
@@ -189,19 +410,36 @@ func (s *Server) receiveHook(w http.ResponseWriter, r *http.Request) error {
 			log.Infof("    REM: id=%d %s", repo.GetID(), repo.GetFullName())
 		}
 
+		if err := s.trans.AddRepos(ctx, evt.GetInstallation().GetID(), evt.RepositoriesAdded); err != nil {
+			return fmt.Errorf("adding repos for installation %d: %v", evt.GetInstallation().GetID(), err)
+		}
+
+		s.trans.RemoveRepos(evt.RepositoriesRemoved)
+
 	// RepositoryEvent is triggered when a repository is created, archived,
 	// unarchived, renamed, edited, transferred, made public, or made private.
 	// (Organization hooks are also trigerred when a repository is deleted.)
+	// UpdateRepo re-applies the configured repository state policies, so
+	// e.g. archiving a repo drops it if archived repos aren't served.
 	// https://developer.github.com/v3/activity/events/types/#repositoryevent
 	case *github.RepositoryEvent:
-		// TODO: Tell Translator to refresh this Repo (based on evt.GetAction())
-		//
 		if log.V(1) {
 			log.Infof("RepositoryEvent: installation=%d repo=%q id=%d action=%q",
 				evt.GetInstallation().GetID(), evt.GetRepo().GetFullName(), evt.GetRepo().GetID(), evt.GetAction())
 		}
 
-		s.trans.UpdateRepo(evt.GetRepo(), evt.GetAction() == "deleted")
+		if err := s.trans.UpdateRepo(ctx, evt.GetInstallation().GetID(), evt.GetRepo(), evt.GetAction() == "deleted"); err != nil {
+			return fmt.Errorf("updating repo %s: %v", evt.GetRepo().GetFullName(), err)
+		}
+
+	// PushEvent is triggered on a push to a repository branch. We only
+	// care about it for noticing changes to the default branch.
+	// https://developer.github.com/v3/activity/events/types/#pushevent
+	case *github.PushEvent:
+		log.V(1).Infof("PushEvent: installation=%d repo=%q id=%d ref=%q",
+			evt.GetInstallation().GetID(), evt.GetRepo().GetFullName(), evt.GetRepo().GetID(), evt.GetRef())
+
+		s.trans.SetDefaultBranch(evt.GetRepo().GetID(), evt.GetRepo().GetDefaultBranch())
 
 	default:
 		log.Warningf("Unhandled Event[%T]: %v", event, event)
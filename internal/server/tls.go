@@ -0,0 +1,116 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+	"toolman.org/base/log/v2"
+)
+
+// tlsConfig returns the TLS configuration for the HTTPS listener, using
+// either the static certificate given by --tls-cert/--tls-key or certificates
+// obtained through ACME for all known hostnames. For the latter, the
+// autocert.Manager is also returned so that the plain HTTP listener may
+// answer its http-01 challenges.
+func (s *Server) tlsConfig() (*tls.Config, *autocert.Manager, error) {
+	if s.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil, nil
+	}
+
+	hosts := s.tlsHosts()
+	log.Infof("Using ACME certificates for: %q", hosts)
+
+	mgr := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(s.ACMECache),
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      s.ACMEEmail,
+	}
+
+	return mgr.TLSConfig(), mgr, nil
+}
+
+// tlsHosts returns the service hostname plus the host portion of each
+// configured translator prefix.
+func (s *Server) tlsHosts() []string {
+	seen := make(map[string]bool)
+	var hosts []string
+
+	add := func(h string) {
+		if h != "" && !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+
+	add(s.Hostname)
+	for _, td := range s.Trans {
+		add(strings.SplitN(td.Prefix, "/", 2)[0])
+	}
+
+	return hosts
+}
+
+// redirect returns the handler for the plain HTTP listener when TLS is
+// enabled. All requests are redirected to HTTPS except ACME challenges
+// (when mgr is non-nil) and, if --http-goget is set, go-get requests
+// which are handed to hndlr.
+func (s *Server) redirect(hndlr http.Handler, mgr *autocert.Manager) http.Handler {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.HTTPGoGet && r.FormValue("go-get") == "1" {
+			hndlr.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if hn, _, err := net.SplitHostPort(host); err == nil {
+			host = hn
+		}
+
+		if s.TLSPort != 443 {
+			host = net.JoinHostPort(host, strconv.FormatInt(s.TLSPort, 10))
+		}
+
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+
+	if mgr != nil {
+		h = mgr.HTTPHandler(h)
+	}
+
+	return h
+}
@@ -22,13 +22,16 @@ package xlat
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/bradleyfalzon/ghinstallation"
-	"github.com/google/go-github/v25/github"
+	"github.com/google/go-github/v27/github"
 )
 
 func (t *Translator) appClient() (*github.Client, error) {
-	tr, err := ghinstallation.NewAppsTransport(http.DefaultTransport, t.IntegrationID, []byte(t.APIKey))
+	mt := &meteredTransport{base: http.DefaultTransport, client: "app", creds: &t.creds}
+
+	tr, err := ghinstallation.NewAppsTransport(mt, t.IntegrationID, []byte(t.APIKey))
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +40,9 @@ func (t *Translator) appClient() (*github.Client, error) {
 }
 
 func (t *Translator) instClient(id int64) (*github.Client, error) {
-	tr, err := ghinstallation.New(http.DefaultTransport, t.IntegrationID, int(id), []byte(t.APIKey))
+	mt := &meteredTransport{base: http.DefaultTransport, client: strconv.FormatInt(id, 10), creds: &t.creds}
+
+	tr, err := ghinstallation.New(mt, t.IntegrationID, int(id), []byte(t.APIKey))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,191 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/google/go-github/v27/github"
+	"toolman.org/base/log/v2"
+
+	"toolman.org/svc/build/go/gogetter/internal/config"
+)
+
+// defaultGoTopic is the repository topic marking a repo as containing Go
+// code for translators that don't configure their own.
+const defaultGoTopic = "go-module"
+
+// detectGo decides whether repo is a Go repository and, if so, returns the
+// modules found within it. A repo matching its translator's deny list is
+// always rejected. Otherwise, it is accepted if it matches the allow list,
+// has Go as its primary language, carries the Go topic, contains at least
+// one go.mod file or, failing all of those, has any Go code at all according
+// to Github's languages breakdown.
+func (t *Translator) detectGo(ctx context.Context, client *github.Client, repo *github.Repository) ([]module, bool, error) {
+	td := t.ownrdef[repo.GetOwner().GetLogin()]
+
+	if td != nil && matchRepo(td.Deny, repo) {
+		log.V(1).Infof("Rejecting denied repo: %s", repo.GetFullName())
+		return nil, false, nil
+	}
+
+	why := goReason(td, repo)
+
+	ri := t.cachedInfo(repo)
+	if ri == nil {
+		mods, err := fetchModules(ctx, client, repo)
+		if err != nil {
+			return nil, false, fmt.Errorf("fetching go.mod files for %s: %v", repo.GetFullName(), err)
+		}
+
+		ri = &repoInfo{pushed: repo.GetPushedAt().Time, branch: defaultBranch(repo.GetDefaultBranch()), mods: mods}
+	}
+
+	if why == "" && len(ri.mods) != 0 {
+		why = "go.mod"
+	}
+
+	if why == "" {
+		if !ri.langs {
+			ok, err := hasGoCode(ctx, client, repo)
+			if err != nil {
+				return nil, false, fmt.Errorf("fetching languages for %s: %v", repo.GetFullName(), err)
+			}
+
+			nri := *ri
+			nri.langs, nri.gocode = true, ok
+			ri = &nri
+		}
+
+		if ri.gocode {
+			why = "languages"
+		}
+	}
+
+	t.storeInfo(repo.GetID(), ri)
+
+	if why == "" {
+		return nil, false, nil
+	}
+
+	log.V(2).Infof("Repo %s is Go (%s)", repo.GetFullName(), why)
+
+	return ri.mods, true, nil
+}
+
+// repoInfo is what was learned from Github about the contents of a repo as
+// of a particular push to its default branch. Since a repo's contents can
+// only change with a push, this lets resyncs skip refetching them. Once
+// stored, a repoInfo is never modified.
+type repoInfo struct {
+	pushed time.Time
+	branch string
+	mods   []module
+	langs  bool // Set once the languages breakdown has been checked
+	gocode bool // Whether that breakdown includes any Go code
+}
+
+// cachedInfo returns the stored repoInfo for repo, or nil if there is none
+// or it's out of date.
+func (t *Translator) cachedInfo(repo *github.Repository) *repoInfo {
+	t.imu.Lock()
+	defer t.imu.Unlock()
+
+	ri := t.info[repo.GetID()]
+	if ri == nil || !ri.pushed.Equal(repo.GetPushedAt().Time) || ri.branch != defaultBranch(repo.GetDefaultBranch()) {
+		return nil
+	}
+
+	return ri
+}
+
+func (t *Translator) storeInfo(id int64, ri *repoInfo) {
+	t.imu.Lock()
+	defer t.imu.Unlock()
+
+	if t.info == nil {
+		t.info = make(map[int64]*repoInfo)
+	}
+
+	t.info[id] = ri
+}
+
+// goReason returns why repo is considered a Go repository based solely on
+// its metadata and translator definition, or an empty string if it isn't.
+func goReason(td *config.TransDef, repo *github.Repository) string {
+	topic := defaultGoTopic
+	if td != nil {
+		if matchRepo(td.Allow, repo) {
+			return "allowed"
+		}
+
+		if td.Topic != "" {
+			topic = td.Topic
+		}
+	}
+
+	if repo.GetLanguage() == "Go" {
+		return "language"
+	}
+
+	for _, tp := range repo.Topics {
+		if tp == topic {
+			return "topic"
+		}
+	}
+
+	return ""
+}
+
+// matchRepo returns true if any of pats matches either the full name
+// (e.g. "owner/name") or just the name of repo. Patterns are as
+// understood by path.Match.
+func matchRepo(pats []string, repo *github.Repository) bool {
+	for _, p := range pats {
+		if ok, _ := path.Match(p, repo.GetFullName()); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(p, repo.GetName()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasGoCode returns true if Github's language breakdown for repo includes
+// any Go code.
+func hasGoCode(ctx context.Context, client *github.Client, repo *github.Repository) (bool, error) {
+	langs, resp, err := client.Repositories.ListLanguages(ctx, repo.GetOwner().GetLogin(), repo.GetName())
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return langs["Go"] > 0, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"fmt"
+	"sort"
+
+	"toolman.org/base/log/v2"
+)
+
+// Diff describes the changes between two translation tables. Each entry is
+// identified by its Go package prefix.
+type Diff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Renamed []Rename `json:"renamed"`
+	Updated []string `json:"updated"`
+}
+
+// Rename records a repository whose package prefix has changed.
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func newDiff() *Diff {
+	return &Diff{Added: []string{}, Removed: []string{}, Renamed: []Rename{}, Updated: []string{}}
+}
+
+func diffTables(ot, nt *table) *Diff {
+	d := newDiff()
+
+	for id, nr := range nt.repos {
+		or := ot.repos[id]
+		switch {
+		case or == nil:
+			d.Added = append(d.Added, nr.pkgpfx)
+		case or.pkgpfx != nr.pkgpfx:
+			d.Renamed = append(d.Renamed, Rename{From: or.pkgpfx, To: nr.pkgpfx})
+		case !or.equal(nr):
+			d.Updated = append(d.Updated, nr.pkgpfx)
+		}
+	}
+
+	for id, or := range ot.repos {
+		if _, ok := nt.repos[id]; !ok {
+			d.Removed = append(d.Removed, or.pkgpfx)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Updated)
+	sort.Slice(d.Renamed, func(i, j int) bool { return d.Renamed[i].From < d.Renamed[j].From })
+
+	return d
+}
+
+// Empty returns true if d contains no changes.
+func (d *Diff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Renamed)+len(d.Updated) == 0
+}
+
+func (d *Diff) String() string {
+	return fmt.Sprintf("added=%d removed=%d renamed=%d updated=%d",
+		len(d.Added), len(d.Removed), len(d.Renamed), len(d.Updated))
+}
+
+// Log writes a summary of d followed by each individual change.
+func (d *Diff) Log() {
+	if d.Empty() {
+		log.V(1).Info("Translation table unchanged")
+		return
+	}
+
+	log.Infof("Translation table changed: %v", d)
+
+	for _, p := range d.Added {
+		log.Infof("    ADD: %s", p)
+	}
+
+	for _, p := range d.Removed {
+		log.Infof("    REM: %s", p)
+	}
+
+	for _, r := range d.Renamed {
+		log.Infof("    MOV: %s -> %s", r.From, r.To)
+	}
+
+	for _, p := range d.Updated {
+		log.Infof("    UPD: %s", p)
+	}
+}
@@ -22,86 +22,368 @@ package xlat
 
 import (
 	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
 
-	"github.com/google/go-github/v25/github"
+	"github.com/google/go-github/v27/github"
 	"toolman.org/base/log/v2"
 )
 
-func (t *Translator) Discover(ctx context.Context) error {
+// Discover lists all repositories from all configured Github App
+// installations and replaces the current translation table with the result.
+// A summary of what changed is logged and returned.
+func (t *Translator) Discover(ctx context.Context) (d *Diff, err error) {
+	defer func(start time.Time) {
+		discoveryDuration.WithLabelValues(Outcome(err)).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
 	inst, err := t.listInstallations(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	nt := newTable()
+
 	for _, in := range inst {
-		ownr := in.GetAccount().GetLogin()
-		pfx, ok := t.ownrpfx[ownr]
-		if !ok {
-			log.Warningf("INST=%q not configured", ownr)
+		repos, err := t.installationRepos(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+
+		if repos == nil {
 			continue
 		}
 
-		log.Infof("INST=%q PREFIX=%q", ownr, pfx)
+		nt.insts[in.GetAccount().GetLogin()] = in.GetID()
+		for _, r := range repos {
+			nt.put(r)
+		}
+	}
+
+	d = t.replace(nt)
+	atomic.StoreInt64(&t.synct, time.Now().UnixNano())
+	log.Infof("Discovery complete: %d repos", len(nt.repos))
+	d.Log()
 
-		repos, err := t.listRepos(ctx, in.GetID())
+	return d, nil
+}
+
+// Resync runs the initial Discover, retrying with an increasing backoff
+// until it succeeds, and then calls Discover again every interval until ctx
+// is cancelled. A zero interval disables periodic resyncs. Errors from
+// periodic resyncs are logged and otherwise ignored; the next attempt
+// happens at the following interval.
+func (t *Translator) Resync(ctx context.Context, interval time.Duration) {
+	if !t.initialSync(ctx) || interval <= 0 {
+		return
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		if _, err := t.Discover(ctx); err != nil {
+			log.Errorf("Resync failed: %v", err)
+		}
+	}
+}
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 5 * time.Minute
+)
+
+func (t *Translator) initialSync(ctx context.Context) bool {
+	for delay := minRetryDelay; ; delay *= 2 {
+		_, err := t.Discover(ctx)
+		if err == nil {
+			t.Dump()
+			return true
+		}
+
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+
+		log.Errorf("Initial discovery failed (retrying in %v): %v", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+// AddInstallation adds all Go repositories accessible through the given
+// Github App installation.
+func (t *Translator) AddInstallation(ctx context.Context, in *github.Installation) error {
+	repos, err := t.installationRepos(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	if repos == nil {
+		return nil
+	}
+
+	t.update(func(tb *table) {
+		tb.insts[in.GetAccount().GetLogin()] = in.GetID()
+		for _, r := range repos {
+			tb.put(r)
+		}
+	})
+
+	log.Infof("Added %d repos from installation %d", len(repos), in.GetID())
+
+	return nil
+}
+
+// RemoveInstallation drops all repos that were provided by the given
+// Github App installation.
+func (t *Translator) RemoveInstallation(instID int64) {
+	var gone []*Repo
+
+	t.update(func(tb *table) { gone = tb.removeInstallation(instID) })
+
+	for _, r := range gone {
+		log.Infof("Deleted repo %s/%s", r.owner, r.name)
+	}
+}
+
+// AddRepos adds the given repositories as accessed through installation
+// instID. Since webhook payloads only carry a partial description of each
+// repository, full details are first fetched using the installation's client.
+func (t *Translator) AddRepos(ctx context.Context, instID int64, repos []*github.Repository) error {
+	if len(repos) == 0 {
+		return nil
+	}
+
+	client, err := t.instClient(instID)
+	if err != nil {
+		return err
+	}
+
+	var found []*Repo
+
+	for _, r := range repos {
+		full, _, err := client.Repositories.GetByID(ctx, r.GetID())
+		if err != nil {
+			return fmt.Errorf("fetching repo %s: %v", r.GetFullName(), err)
+		}
+
+		pfx, ok := t.ownerPrefix(full)
+		if !ok {
+			continue
+		}
+
+		nr, err := t.goRepo(ctx, client, pfx, instID, full)
 		if err != nil {
 			return err
 		}
 
-		for _, r := range repos {
-			t.updateRepo(pfx, r)
+		if nr != nil {
+			found = append(found, nr)
 		}
 	}
 
+	t.update(func(tb *table) {
+		for _, r := range found {
+			tb.put(r)
+		}
+	})
+
 	return nil
 }
 
-func (t *Translator) UpdateRepo(repo *github.Repository, del bool) {
+// RemoveRepos drops the given repositories from the translation table.
+func (t *Translator) RemoveRepos(repos []*github.Repository) {
+	for _, r := range repos {
+		t.deleteRepo(r)
+	}
+}
+
+// UpdateRepo adds, replaces or (if del is true or the repo no longer
+// qualifies for serving) removes repo in the translation table.
+func (t *Translator) UpdateRepo(ctx context.Context, instID int64, repo *github.Repository, del bool) error {
 	if del {
 		t.deleteRepo(repo)
+		return nil
+	}
+
+	// A repo transferred to an owner we don't serve is dropped, along
+	// with its old paths.
+	pfx, ok := t.ownerPrefix(repo)
+	if !ok {
+		t.deleteRepo(repo)
+		return nil
+	}
+
+	client, err := t.instClient(instID)
+	if err != nil {
+		return err
+	}
+
+	nr, err := t.goRepo(ctx, client, pfx, instID, repo)
+	if err != nil {
+		return err
+	}
+
+	// The repo may no longer qualify (e.g. it was just archived and
+	// archived repos are dropped); if so, it's removed.
+	if nr == nil {
+		t.deleteRepo(repo)
+		return nil
+	}
+
+	t.update(func(tb *table) { tb.put(nr) })
+
+	return nil
+}
+
+// SetDefaultBranch records a change to the default branch of the repo
+// with the given id (e.g. as reported by a push event).
+func (t *Translator) SetDefaultBranch(id int64, branch string) {
+	if branch == "" {
 		return
 	}
 
+	if r := t.snapshot().repos[id]; r == nil || r.branch == branch {
+		return
+	}
+
+	t.update(func(tb *table) {
+		if r := tb.repos[id]; r != nil {
+			nr := *r
+			nr.branch = branch
+			tb.put(&nr)
+			log.Infof("Repo %s/%s default branch is now %q", nr.owner, nr.name, branch)
+		}
+	})
+}
+
+// installationRepos returns all Go repos accessible through the given
+// installation. A nil slice is returned if the installation's account is
+// not configured. A failure to examine any one repo doesn't fail the whole
+// installation; that repo's current table entry (if any) is kept instead.
+func (t *Translator) installationRepos(ctx context.Context, in *github.Installation) ([]*Repo, error) {
+	ownr := in.GetAccount().GetLogin()
+	pfx, ok := t.ownrpfx[ownr]
+	if !ok {
+		log.Warningf("INST=%q not configured", ownr)
+		return nil, nil
+	}
+
+	log.Infof("INST=%q PREFIX=%q", ownr, pfx)
+
+	client, err := t.instClient(in.GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := listRepos(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	tb := t.snapshot()
+
+	out := make([]*Repo, 0, len(repos))
+	for _, r := range repos {
+		nr, err := t.goRepo(ctx, client, pfx, in.GetID(), r)
+		if err != nil {
+			if nr = tb.repos[r.GetID()]; nr != nil {
+				log.Warningf("Repo %s: %v (keeping previous entry)", r.GetFullName(), err)
+			} else {
+				log.Warningf("Repo %s: %v (skipping)", r.GetFullName(), err)
+			}
+		}
+
+		if nr != nil {
+			out = append(out, nr)
+		}
+	}
+
+	return out, nil
+}
+
+func (t *Translator) ownerPrefix(repo *github.Repository) (string, bool) {
 	ownr := repo.GetOwner().GetLogin()
 	pfx, ok := t.ownrpfx[ownr]
 
 	if !ok {
 		log.Warningf("Repo owner not configured: %s", ownr)
-		return
 	}
 
-	t.updateRepo(pfx, repo)
+	return pfx, ok
 }
 
-func (t *Translator) updateRepo(pfx string, repo *github.Repository) {
-	if repo.GetLanguage() != "Go" {
-		log.V(1).Infof("Rejecting non-go repo: %s", repo.GetFullName())
-		return
+// goRepo returns a new *Repo for the given Github repository or nil if
+// it isn't a Go repository (see detectGo) or is excluded by policy (see
+// excluded). The repo's package prefix is taken from the go.mod file at its
+// root or, if there is none, derived from the repo's name. A repo whose
+// root go.mod declares a module path outside of pfx is rejected, since the
+// go command would refuse it under any other path. Nested modules with
+// paths under pfx are served as well.
+func (t *Translator) goRepo(ctx context.Context, client *github.Client, pfx string, instID int64, repo *github.Repository) (*Repo, error) {
+	if why := t.excluded(repo); why != "" {
+		log.V(1).Infof("Rejecting %s repo: %s", why, repo.GetFullName())
+		return nil, nil
 	}
 
-	id := repo.GetID()
+	mods, ok, err := t.detectGo(ctx, client, repo)
+	if err != nil {
+		return nil, err
+	}
 
-	if tr := t.repos[id]; tr != nil {
-		delete(t.gopkgs, tr.pkgpfx)
+	if !ok {
+		log.V(1).Infof("Rejecting non-go repo: %s", repo.GetFullName())
+		return nil, nil
 	}
 
-	nr := newRepo(pfx, repo)
+	var (
+		root   string
+		nested []module
+	)
+
+	for _, m := range mods {
+		if !underPrefix(m.path, pfx) {
+			if m.dir == "" {
+				log.Warningf("Rejecting repo %s: module path %q not under prefix %q", repo.GetFullName(), m.path, pfx)
+				return nil, nil
+			}
 
-	t.repos[id] = nr
-	t.gopkgs[nr.pkgpfx] = id
+			log.Warningf("Repo %s: module path %q not under prefix %q; ignoring", repo.GetFullName(), m.path, pfx)
+			continue
+		}
+
+		if m.dir == "" {
+			root = m.path
+			continue
+		}
+
+		nested = append(nested, module{path: trimVersion(m.path), dir: m.dir})
+	}
+
+	return newRepo(pfx, root, nested, instID, repo), nil
 }
 
 func (t *Translator) deleteRepo(repo *github.Repository) {
-	id := repo.GetID()
-	tr := t.repos[id]
+	var tr *Repo
+
+	t.update(func(tb *table) { tr = tb.remove(repo.GetID()) })
 
 	if tr == nil {
 		return
 	}
 
-	delete(t.gopkgs, tr.pkgpfx)
-	delete(t.repos, id)
-
 	log.Infof("Deleted repo %s/%s", tr.owner, tr.name)
 }
 
@@ -0,0 +1,155 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v27/github"
+	"toolman.org/base/log/v2"
+)
+
+// fetchModules returns all Go modules found on repo's default branch. The
+// module at the repository root (if any) has an empty dir. Module paths are
+// returned exactly as declared by each go.mod file. Directories ignored by
+// the go command (vendor, testdata, and those beginning with "." or "_")
+// are skipped.
+func fetchModules(ctx context.Context, client *github.Client, repo *github.Repository) ([]module, error) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+
+	tree, resp, err := client.Git.GetTree(ctx, owner, name, defaultBranch(repo.GetDefaultBranch()), true)
+	if err != nil {
+		// Empty repositories return 409 Conflict
+		if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if tree.GetTruncated() {
+		log.Warningf("Repo %s: tree listing truncated; some nested modules may be missing", repo.GetFullName())
+	}
+
+	var out []module
+
+	for _, e := range tree.Entries {
+		p := e.GetPath()
+		if e.GetType() != "blob" || path.Base(p) != "go.mod" {
+			continue
+		}
+
+		dir := path.Dir(p)
+		if dir == "." {
+			dir = ""
+		} else if ignoredDir(dir) {
+			continue
+		}
+
+		data, err := fetchFile(ctx, client, repo, p)
+		if err != nil {
+			return nil, err
+		}
+
+		if mp := modulePath(data); mp != "" {
+			out = append(out, module{path: mp, dir: dir})
+		}
+	}
+
+	return out, nil
+}
+
+func ignoredDir(dir string) bool {
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == "vendor" || elem == "testdata" || strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchFile returns the content of the named file from repo's default
+// branch, or nil if no such file exists.
+func fetchFile(ctx context.Context, client *github.Client, repo *github.Repository, name string) ([]byte, error) {
+	opts := &github.RepositoryContentGetOptions{Ref: repo.GetDefaultBranch()}
+
+	fc, _, resp, err := client.Repositories.GetContents(ctx, repo.GetOwner().GetLogin(), repo.GetName(), name, opts)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if fc == nil {
+		// name is a directory
+		return nil, nil
+	}
+
+	content, err := fc.GetContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(content), nil
+}
+
+// modulePath returns the module path from the "module" directive of the
+// given go.mod file content, or an empty string if none is found. This
+// mirrors the lenient parsing of golang.org/x/mod/modfile.ModulePath.
+func modulePath(mod []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(mod))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+
+		f := strings.Fields(line)
+		if len(f) < 2 || f[0] != "module" {
+			continue
+		}
+
+		line = strings.TrimSpace(strings.TrimSpace(line)[len("module"):])
+
+		if line[0] == '"' || line[0] == '`' {
+			p, err := strconv.Unquote(line)
+			if err != nil {
+				return ""
+			}
+			return p
+		}
+
+		return line
+	}
+
+	return ""
+}
+
+// underPrefix returns true if pkg is equal to, or is a subpackage of, pfx.
+func underPrefix(pkg, pfx string) bool {
+	return pkg == pfx || strings.HasPrefix(pkg, pfx+"/")
+}
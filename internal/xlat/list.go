@@ -23,7 +23,7 @@ package xlat
 import (
 	"context"
 
-	"github.com/google/go-github/v25/github"
+	"github.com/google/go-github/v27/github"
 )
 
 type listCallback func(*github.ListOptions) (*github.Response, error)
@@ -67,14 +67,9 @@ func (t *Translator) listInstallations(ctx context.Context) ([]*github.Installat
 	return out, nil
 }
 
-func (t *Translator) listRepos(ctx context.Context, id int64) ([]*github.Repository, error) {
+func listRepos(ctx context.Context, client *github.Client) ([]*github.Repository, error) {
 	var out []*github.Repository
 
-	client, err := t.instClient(id)
-	if err != nil {
-		return nil, err
-	}
-
 	lcb := func(lopts *github.ListOptions) (*github.Response, error) {
 		repos, resp, err := client.Apps.ListRepos(ctx, lopts)
 		if err != nil {
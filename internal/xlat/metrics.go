@@ -0,0 +1,120 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	lookupLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gogetter",
+		Name:      "lookup_duration_seconds",
+		Help:      "Latency of import path lookups.",
+		Buckets:   prometheus.ExponentialBuckets(1e-7, 4, 10),
+	})
+
+	discoveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gogetter",
+		Name:      "discovery_duration_seconds",
+		Help:      "Duration of full repository discovery runs by outcome.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"outcome"})
+
+	ownerRepos = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gogetter",
+		Name:      "repos",
+		Help:      "Number of repositories currently served, by owner.",
+	}, []string{"owner"})
+
+	githubCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gogetter",
+		Name:      "github_api_calls_total",
+		Help:      "Number of Github API calls by client (app or installation ID) and HTTP status code.",
+	}, []string{"client", "code"})
+
+	githubRateLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gogetter",
+		Name:      "github_ratelimit_remaining",
+		Help:      "Github API requests remaining in the current rate limit window, by client.",
+	}, []string{"client"})
+)
+
+func init() {
+	prometheus.MustRegister(lookupLatency, discoveryDuration, ownerRepos, githubCalls, githubRateLimit)
+}
+
+// Outcome returns the metric label ("ok" or "error") describing err.
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// recordTable updates the per-owner repo count metrics from tb.
+func recordTable(tb *table) {
+	counts := make(map[string]int)
+	for _, r := range tb.repos {
+		counts[r.owner]++
+	}
+
+	ownerRepos.Reset()
+	for o, n := range counts {
+		ownerRepos.WithLabelValues(o).Set(float64(n))
+	}
+}
+
+// meteredTransport counts Github API calls and tracks the remaining rate
+// limit as reported by each response. It also notes whether Github is
+// accepting the Translator's credentials.
+type meteredTransport struct {
+	base   http.RoundTripper
+	client string
+	creds  *int32
+}
+
+func (mt *meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := mt.base.RoundTrip(req)
+	if err != nil {
+		githubCalls.WithLabelValues(mt.client, "error").Inc()
+		return resp, err
+	}
+
+	githubCalls.WithLabelValues(mt.client, strconv.Itoa(resp.StatusCode)).Inc()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		atomic.StoreInt32(mt.creds, 1)
+	case resp.StatusCode < 300:
+		atomic.StoreInt32(mt.creds, 0)
+	}
+
+	if n, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		githubRateLimit.WithLabelValues(mt.client).Set(float64(n))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"strings"
+	"time"
+)
+
+// alias is a module path left behind when its repository was renamed or
+// transferred. For a grace period after the move, requests for the old
+// path are answered with the repo's current location (so existing imports
+// keep working) and flagged as deprecated.
+type alias struct {
+	repo  int64     // Github repo id
+	dir   string    // Module root directory relative to the repository root
+	since time.Time // When the module moved
+}
+
+// addMoved records an alias for each of or's module paths that is served
+// by neither nr (which must be the same repo) nor any other repo.
+func (tb *table) addMoved(or, nr *Repo, now time.Time) {
+	for _, m := range or.Modules() {
+		if tb.pkgs.get(m.path) != nil {
+			continue
+		}
+
+		if _, ok := tb.moved[m.path]; !ok {
+			tb.moved[m.path] = &alias{repo: nr.id, dir: m.dir, since: now}
+		}
+	}
+}
+
+// inherit carries over all still valid aliases from ot and adds those for
+// any repos that have moved between ot and tb.
+func (tb *table) inherit(ot *table, now time.Time) {
+	for p, a := range ot.moved {
+		if tb.pkgs.get(p) == nil {
+			tb.moved[p] = a
+		}
+	}
+
+	for id, or := range ot.repos {
+		if nr := tb.repos[id]; nr != nil {
+			tb.addMoved(or, nr, now)
+		}
+	}
+}
+
+// pruneMoved drops aliases older than grace along with those whose repo
+// (or module) is no longer being served.
+func (tb *table) pruneMoved(grace time.Duration, now time.Time) {
+	for p, a := range tb.moved {
+		if !now.Before(a.since.Add(grace)) || tb.resolve(p, a, grace) == nil {
+			delete(tb.moved, p)
+		}
+	}
+}
+
+// lookupMoved returns a Module for the longest alias that is a prefix of
+// p, or nil if there is none (or it has expired).
+func (tb *table) lookupMoved(p string, grace time.Duration) *Module {
+	if len(tb.moved) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	elems := splitPath(p)
+
+	for i := len(elems); i > 0; i-- {
+		ap := strings.Join(elems[:i], "/")
+
+		a := tb.moved[ap]
+		if a == nil || !now.Before(a.since.Add(grace)) {
+			continue
+		}
+
+		if m := tb.resolve(ap, a, grace); m != nil {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// resolve returns a Module serving the old path p from the current
+// location of a's module.
+func (tb *table) resolve(p string, a *alias, grace time.Duration) *Module {
+	r := tb.repos[a.repo]
+	if r == nil {
+		return nil
+	}
+
+	for _, m := range r.Modules() {
+		if m.dir == a.dir {
+			return &Module{Repo: r, path: p, dir: m.dir, movedTo: m.path, expires: a.since.Add(grace)}
+		}
+	}
+
+	return nil
+}
+
+// MovedTo returns the current import path of m's module if m is an old
+// path being served after its repository was renamed or transferred.
+// Otherwise, an empty string is returned.
+func (m *Module) MovedTo() string { return m.movedTo }
+
+// Expires returns when an old import path (see MovedTo) will no longer
+// be served.
+func (m *Module) Expires() time.Time { return m.expires }
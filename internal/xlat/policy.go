@@ -0,0 +1,50 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"github.com/google/go-github/v27/github"
+
+	"toolman.org/svc/build/go/gogetter/internal/config"
+)
+
+// excluded returns the reason repo should not be served under the
+// configured repository state policies, or an empty string if it should.
+// Archived repos that aren't excluded are still served, but marked as
+// archived.
+func (t *Translator) excluded(repo *github.Repository) string {
+	for _, p := range []struct {
+		reason string
+		state  bool
+		policy string
+	}{
+		{"archived", repo.GetArchived(), t.ArchivedRepos},
+		{"fork", repo.GetFork(), t.ForkRepos},
+		{"template", repo.GetIsTemplate(), t.TemplateRepos},
+		{"disabled", repo.GetDisabled(), t.DisabledRepos},
+	} {
+		if p.state && p.policy == config.PolicyDrop {
+			return p.reason
+		}
+	}
+
+	return ""
+}
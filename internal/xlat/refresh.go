@@ -0,0 +1,176 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v27/github"
+	"toolman.org/base/log/v2"
+)
+
+// RefreshRepo re-fetches a single repository from Github and updates the
+// translation table accordingly. The repository is identified either by its
+// numeric Github ID (which must already be known) or by its "owner/name".
+// A repository that no longer exists (or is no longer a Go repo) is removed.
+func (t *Translator) RefreshRepo(ctx context.Context, ref string) (*Diff, error) {
+	tb := t.snapshot()
+
+	var (
+		instID int64
+		fetch  func(*github.Client) (*github.Repository, *github.Response, error)
+		known  *Repo
+	)
+
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		if known = tb.repos[id]; known == nil {
+			return nil, fmt.Errorf("unknown repository id %d; try owner/name instead", id)
+		}
+
+		instID = known.instid
+		fetch = func(c *github.Client) (*github.Repository, *github.Response, error) {
+			return c.Repositories.GetByID(ctx, id)
+		}
+	} else {
+		parts := strings.Split(ref, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("bad repository reference %q; want ID or owner/name", ref)
+		}
+
+		if instID = tb.insts[parts[0]]; instID == 0 {
+			return nil, fmt.Errorf("no installation for owner %q", parts[0])
+		}
+
+		for _, r := range tb.repos {
+			if r.owner == parts[0] && r.name == parts[1] {
+				known = r
+				break
+			}
+		}
+
+		fetch = func(c *github.Client) (*github.Repository, *github.Response, error) {
+			return c.Repositories.Get(ctx, parts[0], parts[1])
+		}
+	}
+
+	client, err := t.instClient(instID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, resp, err := fetch(client)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			if known == nil {
+				return newDiff(), nil
+			}
+			return t.update(func(tb *table) { tb.remove(known.id) }), nil
+		}
+		return nil, err
+	}
+
+	pfx, ok := t.ownerPrefix(repo)
+	if !ok {
+		return nil, fmt.Errorf("repository owner %q not configured", repo.GetOwner().GetLogin())
+	}
+
+	nr, err := t.goRepo(ctx, client, pfx, instID, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	d := t.update(func(tb *table) {
+		if nr != nil {
+			tb.put(nr)
+		} else {
+			tb.remove(repo.GetID())
+		}
+	})
+
+	d.Log()
+
+	return d, nil
+}
+
+// RefreshOwner re-lists all repositories provided by a single Github App
+// installation and replaces that installation's repos in the translation
+// table. The installation is identified either by its numeric ID or by the
+// login name of the account it belongs to.
+func (t *Translator) RefreshOwner(ctx context.Context, ref string) (*Diff, error) {
+	insts, err := t.listInstallations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var in *github.Installation
+	for _, i := range insts {
+		if strconv.FormatInt(i.GetID(), 10) == ref || i.GetAccount().GetLogin() == ref {
+			in = i
+			break
+		}
+	}
+
+	if in == nil {
+		return nil, fmt.Errorf("no installation found for %q", ref)
+	}
+
+	repos, err := t.installationRepos(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	if repos == nil {
+		return nil, fmt.Errorf("installation owner %q not configured", in.GetAccount().GetLogin())
+	}
+
+	// Repos are put before stale ones are removed (rather than removing
+	// the whole installation first) so that put sees any renames and
+	// keeps serving the old import paths.
+	d := t.update(func(tb *table) {
+		for o, id := range tb.insts {
+			if id == in.GetID() {
+				delete(tb.insts, o)
+			}
+		}
+		tb.insts[in.GetAccount().GetLogin()] = in.GetID()
+
+		keep := make(map[int64]bool, len(repos))
+		for _, r := range repos {
+			tb.put(r)
+			keep[r.id] = true
+		}
+
+		for id, r := range tb.repos {
+			if r.instid == in.GetID() && !keep[id] {
+				tb.remove(id)
+			}
+		}
+	})
+
+	log.Infof("Refreshed %d repos from installation %d (%s)", len(repos), in.GetID(), in.GetAccount().GetLogin())
+	d.Log()
+
+	return d, nil
+}
@@ -25,49 +25,181 @@ import (
 	"io"
 	"path"
 	"strings"
+	"time"
 
-	"github.com/google/go-github/v25/github"
+	"github.com/google/go-github/v27/github"
 )
 
 type Repo struct {
-	id      int64  // Github repository id
-	owner   string // Github repository owner name (either user or org)
-	name    string // Github repository name
-	pkgpfx  string // Go package prefix corresponding to the repository root
-	private bool   // Private repo flag
-	htmlurl string // HTML URL for source browsers
-	puburl  string // Clone URL for public repos
-	privurl string // Clone URL for private repos
+	id      int64     // Github repository id
+	instid  int64     // Github App installation id providing access to this repo
+	owner   string    // Github repository owner name (either user or org)
+	name    string    // Github repository name
+	pkgpfx  string    // Go package prefix corresponding to the repository root
+	mods    []module  // Nested modules (other than the one at the repository root)
+	private bool      // Private repo flag
+	archive bool      // Archived (read-only) repo flag
+	htmlurl string    // HTML URL for source browsers
+	branch  string    // Default branch (for source browser links)
+	puburl  string    // Clone URL for public repos
+	privurl string    // Clone URL for private repos
+	desc    string    // Repository description
+	updated time.Time // Time of last push to the repository
 }
 
-func newRepo(pfx string, gr *github.Repository) *Repo {
+// module describes a Go module found in a subdirectory of a repository.
+type module struct {
+	path string // Module path (minus any major version suffix)
+	dir  string // Module root directory relative to the repository root
+}
+
+// newRepo returns a *Repo for gr. If modpath is not empty, it is used as
+// the repo's package prefix (minus any major version suffix); otherwise,
+// the package prefix is derived from pfx and the name of the repository.
+func newRepo(pfx, modpath string, nested []module, instID int64, gr *github.Repository) *Repo {
 	nam := gr.GetName()
 
-	// Translates pfx="example.com" and nam="one-two-buckle--my--shoe"
-	// into "example.com/one/two/buckle-my-shoe"
-	pkg := path.Join(pfx, strings.Replace(strings.Replace(nam, "-", "/", -1), "//", "-", -1))
+	pkg := trimVersion(modpath)
+	if pkg == "" {
+		pkg = namePackage(pfx, nam)
+	}
 
 	return &Repo{
 		id:      gr.GetID(),
+		instid:  instID,
 		owner:   gr.GetOwner().GetLogin(),
 		name:    nam,
 		pkgpfx:  pkg,
+		mods:    nested,
 		private: gr.GetPrivate(),
+		archive: gr.GetArchived(),
 		htmlurl: gr.GetHTMLURL(),
+		branch:  defaultBranch(gr.GetDefaultBranch()),
 		puburl:  gr.GetCloneURL(),
 		privurl: strings.Replace(gr.GetGitURL(), "git://", "ssh://git@", 1),
+		desc:    gr.GetDescription(),
+		updated: gr.GetPushedAt().Time,
+	}
+}
+
+// namePackage translates pfx="example.com" and nam="one-two-buckle--my--shoe"
+// into "example.com/one/two/buckle-my-shoe"
+func namePackage(pfx, nam string) string {
+	return path.Join(pfx, strings.Replace(strings.Replace(nam, "-", "/", -1), "//", "-", -1))
+}
+
+// equal compares all fields except the push time, which changes too
+// frequently to be of interest.
+func (r *Repo) equal(o *Repo) bool {
+	if r.id != o.id || r.instid != o.instid || r.owner != o.owner ||
+		r.name != o.name || r.pkgpfx != o.pkgpfx || r.private != o.private || r.archive != o.archive ||
+		r.htmlurl != o.htmlurl || r.branch != o.branch ||
+		r.puburl != o.puburl || r.privurl != o.privurl || r.desc != o.desc {
+		return false
+	}
+
+	if len(r.mods) != len(o.mods) {
+		return false
+	}
+
+	for i := range r.mods {
+		if r.mods[i] != o.mods[i] {
+			return false
+		}
 	}
+
+	return true
+}
+
+// Modules returns all of the modules served from r, starting with the
+// one at the repository root.
+func (r *Repo) Modules() []*Module {
+	out := []*Module{{Repo: r, path: r.pkgpfx}}
+	for _, m := range r.mods {
+		out = append(out, &Module{Repo: r, path: m.path, dir: m.dir})
+	}
+	return out
+}
+
+// Module is a Go module served from a Repo; either the module at the
+// repository root or one nested in a subdirectory.
+type Module struct {
+	*Repo
+	path string // Module path (minus any major version suffix)
+	dir  string // Module root directory relative to the repository root
+
+	movedTo string    // Current module path, if path is an old alias
+	expires time.Time // When an alias stops being served
 }
 
 const (
-	importTag = `<meta name="go-import" content="%s git %s">` + "\r\n"
-	sourceTag = `<meta name="go-source" content="%[1]s %[2]s %[2]s/tree/master{/dir} %[2]s/blob/master/{/dir}/{file}#L{line}">` + "\r\n"
+	importTag = `<meta name="go-import" content="%s git %s%s">` + "\r\n"
+	sourceTag = `<meta name="go-source" content="%s %s %s{/dir} %s{/dir}/{file}#L{line}">` + "\r\n"
 )
 
-func (r *Repo) WriteImportTags(w io.Writer) {
-	// fmt.Fprintf(w, `<meta name="go-import" content="%s git %s">%s`, r.pkgpfx, r.goGetURL(), "\r\n")
-	fmt.Fprintf(w, importTag, r.pkgpfx, r.goGetURL())
-	fmt.Fprintf(w, sourceTag, r.pkgpfx, r.htmlurl)
+func (m *Module) WriteImportTags(w io.Writer) {
+	root, subdir := m.importRoot()
+
+	tree := m.htmlurl + "/tree/" + m.branch
+	blob := m.htmlurl + "/blob/" + m.branch
+	if subdir != "" {
+		subdir = " " + subdir
+		tree += "/" + m.dir
+		blob += "/" + m.dir
+	}
+
+	fmt.Fprintf(w, importTag, root, m.goGetURL(), subdir)
+	fmt.Fprintf(w, sourceTag, root, m.htmlurl, tree, blob)
+}
+
+// importRoot returns the import path prefix and the repository
+// subdirectory that should be advertised in m's go-import tag. For a
+// module whose path mirrors its location in the repository, this is the
+// root of the repository (with no subdirectory) and the go command will
+// locate the module within the repository by itself. Otherwise, the module
+// path is advertised along with the subdirectory where it can be found.
+//
+// An old path kept after a rename or transfer is always advertised as is,
+// since the repository's current package prefix no longer matches it.
+func (m *Module) importRoot() (string, string) {
+	if m.movedTo != "" {
+		return m.path, m.dir
+	}
+
+	if m.dir == "" || m.path == path.Join(m.pkgpfx, m.dir) {
+		return m.pkgpfx, ""
+	}
+
+	return m.path, m.dir
+}
+
+// Path returns m's import path.
+func (m *Module) Path() string { return m.path }
+
+// Dir returns the directory containing m relative to the repository root.
+func (m *Module) Dir() string { return m.dir }
+
+func (r *Repo) InstallationID() int64 { return r.instid }
+func (r *Repo) ID() int64             { return r.id }
+func (r *Repo) Owner() string         { return r.owner }
+func (r *Repo) Name() string          { return r.name }
+func (r *Repo) FullName() string      { return r.owner + "/" + r.name }
+func (r *Repo) Private() bool         { return r.private }
+func (r *Repo) Archived() bool        { return r.archive }
+func (r *Repo) HTMLURL() string       { return r.htmlurl }
+func (r *Repo) Branch() string        { return r.branch }
+func (r *Repo) PublicURL() string     { return r.puburl }
+func (r *Repo) PrivateURL() string    { return r.privurl }
+func (r *Repo) Description() string   { return r.desc }
+func (r *Repo) Updated() time.Time    { return r.updated }
+
+// defaultBranch returns b, or "master" if b is empty (as it may be for
+// repos restored from older snapshots).
+func defaultBranch(b string) string {
+	if b == "" {
+		return "master"
+	}
+	return b
 }
 
 func (r *Repo) goGetURL() string {
@@ -0,0 +1,223 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"toolman.org/base/log/v2"
+)
+
+const snapshotVersion = 1
+
+// snapshot file format
+type snapFile struct {
+	Version int         `json:"version"`
+	Saved   time.Time   `json:"saved"`
+	Repos   []*snapRepo `json:"repos"`
+
+	Installations map[string]int64      `json:"installations,omitempty"`
+	Moved         map[string]*snapAlias `json:"moved,omitempty"`
+}
+
+type snapAlias struct {
+	Repo  int64     `json:"repo"`
+	Dir   string    `json:"dir,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+type snapRepo struct {
+	ID      int64         `json:"id"`
+	InstID  int64         `json:"installation_id"`
+	Owner   string        `json:"owner"`
+	Name    string        `json:"name"`
+	PkgPfx  string        `json:"package_prefix"`
+	Modules []*snapModule `json:"modules,omitempty"`
+	Private bool          `json:"private,omitempty"`
+	Archive bool          `json:"archived,omitempty"`
+	HTMLURL string        `json:"html_url"`
+	Branch  string        `json:"default_branch,omitempty"`
+	PubURL  string        `json:"public_url"`
+	PrivURL string        `json:"private_url"`
+	Desc    string        `json:"description,omitempty"`
+	Updated time.Time     `json:"updated"`
+}
+
+type snapModule struct {
+	Path string `json:"path"`
+	Dir  string `json:"dir"`
+}
+
+func (r *Repo) toSnap() *snapRepo {
+	sr := &snapRepo{
+		ID:      r.id,
+		InstID:  r.instid,
+		Owner:   r.owner,
+		Name:    r.name,
+		PkgPfx:  r.pkgpfx,
+		Private: r.private,
+		Archive: r.archive,
+		HTMLURL: r.htmlurl,
+		Branch:  r.branch,
+		PubURL:  r.puburl,
+		PrivURL: r.privurl,
+		Desc:    r.desc,
+		Updated: r.updated,
+	}
+
+	for _, m := range r.mods {
+		sr.Modules = append(sr.Modules, &snapModule{Path: m.path, Dir: m.dir})
+	}
+
+	return sr
+}
+
+func (sr *snapRepo) toRepo() *Repo {
+	r := &Repo{
+		id:      sr.ID,
+		instid:  sr.InstID,
+		owner:   sr.Owner,
+		name:    sr.Name,
+		pkgpfx:  sr.PkgPfx,
+		private: sr.Private,
+		archive: sr.Archive,
+		htmlurl: sr.HTMLURL,
+		branch:  defaultBranch(sr.Branch),
+		puburl:  sr.PubURL,
+		privurl: sr.PrivURL,
+		desc:    sr.Desc,
+		updated: sr.Updated,
+	}
+
+	for _, m := range sr.Modules {
+		r.mods = append(r.mods, module{path: m.Path, dir: m.Dir})
+	}
+
+	return r
+}
+
+// LoadSnapshot populates the translation table from the configured snapshot
+// file, allowing requests to be served from the last known state while
+// discovery reconciles it with Github. A missing snapshot file is not an
+// error.
+func (t *Translator) LoadSnapshot() error {
+	if t.Snapshot == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(t.Snapshot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var sf snapFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return fmt.Errorf("snapshot %q: %v", t.Snapshot, err)
+	}
+
+	if sf.Version != snapshotVersion {
+		return fmt.Errorf("snapshot %q: unsupported version %d", t.Snapshot, sf.Version)
+	}
+
+	nt := newTable()
+	for _, sr := range sf.Repos {
+		nt.put(sr.toRepo())
+	}
+
+	for o, id := range sf.Installations {
+		nt.insts[o] = id
+	}
+
+	for p, sa := range sf.Moved {
+		nt.moved[p] = &alias{repo: sa.Repo, dir: sa.Dir, since: sa.Since}
+	}
+
+	t.restore(nt)
+
+	log.Infof("Loaded %d repos from snapshot %q (saved %v)", len(nt.repos), t.Snapshot, sf.Saved)
+
+	return nil
+}
+
+// saveSnapshot writes tb to the configured snapshot file. The new snapshot
+// is written to a temporary file which is then renamed into place so that
+// readers never see a partially written snapshot. Must be called with t.mu
+// held.
+func (t *Translator) saveSnapshot(tb *table) {
+	if t.Snapshot == "" {
+		return
+	}
+
+	if err := writeSnapshot(t.Snapshot, tb); err != nil {
+		log.Errorf("Failed writing snapshot: %v", err)
+	}
+}
+
+func writeSnapshot(name string, tb *table) error {
+	sf := &snapFile{Version: snapshotVersion, Saved: time.Now(), Installations: tb.insts}
+	for _, r := range tb.repos {
+		sf.Repos = append(sf.Repos, r.toSnap())
+	}
+
+	if len(tb.moved) != 0 {
+		sf.Moved = make(map[string]*snapAlias, len(tb.moved))
+		for p, a := range tb.moved {
+			sf.Moved[p] = &snapAlias{Repo: a.repo, Dir: a.dir, Since: a.since}
+		}
+	}
+
+	data, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
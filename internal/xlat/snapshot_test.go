@@ -0,0 +1,82 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSnapshotKeepsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "table.json")
+
+	tb := newTable()
+	tb.put(testRepo(1, "example.com/a"))
+	tb.insts["owner"] = 1
+
+	if err := writeSnapshot(fn, tb); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := testTranslator()
+	tr.Snapshot = fn
+
+	if err := tr.LoadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := tr.Lookup("example.com/a/pkg"); m == nil || m.id != 1 {
+		t.Errorf("snapshot repo not served: %v", m)
+	}
+
+	if !tr.Ready() {
+		t.Error("translator not ready after loading snapshot")
+	}
+
+	after, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before, after) {
+		t.Error("snapshot rewritten by LoadSnapshot")
+	}
+
+	tr.update(func(tb *table) { tb.put(testRepo(2, "example.com/b")) })
+
+	if after, _ = ioutil.ReadFile(fn); bytes.Equal(before, after) {
+		t.Error("snapshot not rewritten after update")
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// table holds the Translator's lookup state. A table is never modified once
+// it has been published; writers instead clone the current table, apply
+// their changes to the copy and then swap it in with a single atomic store.
+// This lets Lookup run lock-free from any number of HTTP handlers while
+// webhook events (or discovery) update the mappings.
+type table struct {
+	repos map[int64]*Repo   // Github repo id -> *Repo
+	pkgs  *trie             // Go module path -> *Module
+	insts map[string]int64  // Github repo owner -> App installation id
+	moved map[string]*alias // Old module path -> alias; see moved.go
+}
+
+func newTable() *table {
+	return &table{
+		repos: make(map[int64]*Repo),
+		pkgs:  &trie{},
+		insts: make(map[string]int64),
+		moved: make(map[string]*alias),
+	}
+}
+
+func (tb *table) clone() *table {
+	nt := &table{
+		repos: make(map[int64]*Repo, len(tb.repos)),
+		pkgs:  tb.pkgs,
+		insts: make(map[string]int64, len(tb.insts)),
+		moved: make(map[string]*alias, len(tb.moved)),
+	}
+
+	for id, r := range tb.repos {
+		nt.repos[id] = r
+	}
+
+	for o, id := range tb.insts {
+		nt.insts[o] = id
+	}
+
+	for p, a := range tb.moved {
+		nt.moved[p] = a
+	}
+
+	return nt
+}
+
+func (tb *table) put(r *Repo) {
+	or := tb.repos[r.id]
+	if or != nil {
+		tb.unmap(or)
+	}
+
+	tb.repos[r.id] = r
+	for _, m := range r.Modules() {
+		tb.pkgs = tb.pkgs.put(m.path, m)
+		delete(tb.moved, m.path)
+	}
+
+	if or != nil {
+		tb.addMoved(or, r, time.Now())
+	}
+}
+
+func (tb *table) remove(id int64) *Repo {
+	r := tb.repos[id]
+	if r == nil {
+		return nil
+	}
+
+	tb.unmap(r)
+	delete(tb.repos, id)
+
+	return r
+}
+
+// unmap removes all of r's module paths from tb.pkgs (unless they have
+// since been claimed by some other repo).
+func (tb *table) unmap(r *Repo) {
+	for _, m := range r.Modules() {
+		if cm := tb.pkgs.get(m.path); cm != nil && cm.id == r.id {
+			tb.pkgs = tb.pkgs.del(m.path)
+		}
+	}
+}
+
+// removeInstallation removes the given installation along with all repos
+// it provides and returns those repos.
+func (tb *table) removeInstallation(instID int64) []*Repo {
+	var out []*Repo
+
+	for o, id := range tb.insts {
+		if id == instID {
+			delete(tb.insts, o)
+		}
+	}
+
+	for id, r := range tb.repos {
+		if r.instid == instID {
+			out = append(out, tb.remove(id))
+		}
+	}
+
+	return out
+}
+
+// replace publishes nt as the new current table and returns the differences
+// between it and the table it replaced.
+func (t *Translator) replace(nt *table) *Diff {
+	return t.publish(nt, true)
+}
+
+// restore publishes nt, as just loaded from the snapshot file, without
+// writing it back out (which would only reset its saved time).
+func (t *Translator) restore(nt *table) {
+	t.publish(nt, false)
+}
+
+func (t *Translator) publish(nt *table, save bool) *Diff {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ot := t.snapshot()
+	nt.inherit(ot, time.Now())
+	nt.pruneMoved(t.RenameGrace, time.Now())
+
+	d := diffTables(ot, nt)
+	t.tbl.Store(nt)
+	atomic.StoreInt32(&t.ready, 1)
+	recordTable(nt)
+
+	if save && (!d.Empty() || !nt.sameExtras(ot)) {
+		t.saveSnapshot(nt)
+	}
+
+	return d
+}
+
+// Ready returns true once the translation table has been fully populated
+// at least once.
+func (t *Translator) Ready() bool {
+	return atomic.LoadInt32(&t.ready) != 0
+}
+
+// LastSync returns the time of the last successful discovery, or the zero
+// time if discovery has yet to succeed.
+func (t *Translator) LastSync() time.Time {
+	if ns := atomic.LoadInt64(&t.synct); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// CredentialsValid returns false if Github's most recent response to an
+// API request indicated that our credentials were rejected.
+func (t *Translator) CredentialsValid() bool {
+	return atomic.LoadInt32(&t.creds) == 0
+}
+
+// snapshot returns the currently published table. The returned value must
+// be treated as read-only.
+func (t *Translator) snapshot() *table {
+	return t.tbl.Load().(*table)
+}
+
+// update applies fn to a private copy of the current table and, once fn
+// returns, publishes that copy for all subsequent readers and returns what
+// changed. If nothing did, the current table is left as is. Concurrent calls
+// to update are serialized.
+func (t *Translator) update(fn func(*table)) *Diff {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ot := t.snapshot()
+	nt := ot.clone()
+	fn(nt)
+	nt.pruneMoved(t.RenameGrace, time.Now())
+
+	d := diffTables(ot, nt)
+	if d.Empty() && nt.sameExtras(ot) {
+		return d
+	}
+
+	t.tbl.Store(nt)
+	t.saveSnapshot(nt)
+	recordTable(nt)
+
+	return d
+}
+
+// sameExtras returns true if tb and o have the same installations, aliases
+// and repo push times; i.e. the state not covered by a Diff.
+func (tb *table) sameExtras(o *table) bool {
+	if len(tb.insts) != len(o.insts) || len(tb.moved) != len(o.moved) {
+		return false
+	}
+
+	for id, r := range tb.repos {
+		if or := o.repos[id]; or != nil && !or.updated.Equal(r.updated) {
+			return false
+		}
+	}
+
+	for k, v := range tb.insts {
+		if ov, ok := o.insts[k]; !ok || ov != v {
+			return false
+		}
+	}
+
+	for k, v := range tb.moved {
+		if o.moved[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,266 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v27/github"
+	"toolman.org/svc/build/go/gogetter/internal/config"
+)
+
+func testTranslator() *Translator {
+	t := &Translator{
+		ownrpfx: map[string]string{"owner": "example.com"},
+		Config:  &config.Config{},
+	}
+	t.tbl.Store(newTable())
+	return t
+}
+
+func testRepo(id int64, pfx string, nested ...module) *Repo {
+	name := pfx[strings.LastIndex(pfx, "/")+1:]
+	return &Repo{
+		id:      id,
+		instid:  1,
+		owner:   "owner",
+		name:    name,
+		pkgpfx:  pfx,
+		mods:    nested,
+		htmlurl: "https://github.com/owner/" + name,
+		branch:  "master",
+	}
+}
+
+func testGHRepo(id int64, name string) *github.Repository {
+	return &github.Repository{
+		ID:    github.Int64(id),
+		Name:  github.String(name),
+		Owner: &github.User{Login: github.String("owner")},
+	}
+}
+
+func TestTablePutUnmap(t *testing.T) {
+	tb := newTable()
+
+	a := testRepo(1, "example.com/a", module{path: "example.com/a/sub", dir: "sub"})
+	tb.put(a)
+
+	if m := tb.pkgs.get("example.com/a/sub"); m == nil || m.id != 1 {
+		t.Fatalf("nested module not mapped: %v", m)
+	}
+
+	// Repo 2 takes over repo 1's root path.
+	b := testRepo(2, "example.com/a")
+	tb.put(b)
+
+	tb.remove(1)
+
+	if m := tb.pkgs.get("example.com/a"); m == nil || m.id != 2 {
+		t.Errorf("path claimed by repo 2 was unmapped with repo 1: %v", m)
+	}
+
+	if m := tb.pkgs.get("example.com/a/sub"); m != nil {
+		t.Errorf("repo 1's nested module still mapped: %v", m)
+	}
+
+	if _, ok := tb.repos[1]; ok {
+		t.Error("repo 1 still present after remove")
+	}
+}
+
+func TestTablePutReplacesPaths(t *testing.T) {
+	tb := newTable()
+	tb.put(testRepo(1, "example.com/old"))
+	tb.put(testRepo(1, "example.com/new"))
+
+	if m := tb.pkgs.get("example.com/old"); m != nil {
+		t.Errorf("old path still mapped after put: %v", m)
+	}
+
+	if m := tb.pkgs.get("example.com/new"); m == nil || m.id != 1 {
+		t.Errorf("new path not mapped: %v", m)
+	}
+}
+
+func TestTableClone(t *testing.T) {
+	tb := newTable()
+	tb.put(testRepo(1, "example.com/a"))
+	tb.insts["owner"] = 1
+
+	nt := tb.clone()
+	nt.put(testRepo(2, "example.com/b"))
+	nt.remove(1)
+	nt.insts["other"] = 2
+
+	if tb.repos[1] == nil || tb.repos[2] != nil {
+		t.Errorf("clone modified original repos: %v", tb.repos)
+	}
+
+	if tb.pkgs.get("example.com/a") == nil || tb.pkgs.get("example.com/b") != nil {
+		t.Error("clone modified original trie")
+	}
+
+	if _, ok := tb.insts["other"]; ok {
+		t.Error("clone modified original installations")
+	}
+}
+
+func TestTableRemoveInstallation(t *testing.T) {
+	tb := newTable()
+	tb.put(testRepo(1, "example.com/a"))
+
+	other := testRepo(2, "example.com/b")
+	other.instid = 2
+	tb.put(other)
+	tb.insts["owner"] = 1
+
+	if gone := tb.removeInstallation(1); len(gone) != 1 || gone[0].id != 1 {
+		t.Errorf("removeInstallation(1) = %v", gone)
+	}
+
+	if tb.repos[2] == nil || tb.pkgs.get("example.com/b") == nil {
+		t.Error("repo from other installation removed")
+	}
+
+	if _, ok := tb.insts["owner"]; ok {
+		t.Error("installation still recorded")
+	}
+}
+
+// TestConcurrentLookup hammers Lookup while repos are being added and
+// removed and the table is being replaced; it's mostly of interest when
+// run with -race.
+func TestConcurrentLookup(t *testing.T) {
+	tr := testTranslator()
+
+	const (
+		repos   = 50
+		readers = 8
+		rounds  = 200
+	)
+
+	name := func(i int) string { return fmt.Sprintf("r%d", i) }
+
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				p := "example.com/" + name((i+n)%repos) + "/pkg"
+				if m := tr.Lookup(p); m != nil && !strings.HasPrefix(p, m.path) {
+					t.Errorf("Lookup(%q) returned unrelated module %q", p, m.path)
+				}
+				tr.Trace(p)
+				tr.Repos()
+			}
+		}(i)
+	}
+
+	for n := 0; n < rounds; n++ {
+		id := int64(n % repos)
+
+		// UpdateRepo needs Github to examine the repo; this is what it
+		// does once it has.
+		nr := testRepo(id, "example.com/"+name(int(id)))
+		tr.update(func(tb *table) { tb.put(nr) })
+
+		if n%3 == 0 {
+			tr.deleteRepo(testGHRepo(id, nr.name))
+		}
+
+		if n%50 == 0 {
+			nt := newTable()
+			for i := 0; i < repos; i += 2 {
+				nt.put(testRepo(int64(i), "example.com/"+name(i)))
+			}
+			tr.replace(nt)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if !tr.Ready() {
+		t.Error("translator not ready after replace")
+	}
+
+	for id, r := range tr.snapshot().repos {
+		if want := "example.com/" + name(int(id)); r.pkgpfx != want {
+			t.Errorf("repo %d has prefix %q; wanted %q", id, r.pkgpfx, want)
+		}
+	}
+}
+
+func TestUpdateUnchanged(t *testing.T) {
+	tr := testTranslator()
+	tr.update(func(tb *table) { tb.put(testRepo(1, "example.com/a")) })
+
+	before := tr.snapshot()
+
+	tr.update(func(tb *table) { tb.remove(2) })
+	tr.update(func(tb *table) { tb.put(testRepo(1, "example.com/a")) })
+
+	if tr.snapshot() != before {
+		t.Error("table replaced by update that changed nothing")
+	}
+
+	tr.update(func(tb *table) { tb.insts["owner"] = 1 })
+
+	if tr.snapshot() == before {
+		t.Error("table not replaced after installation change")
+	}
+}
+
+func TestUpdateRepoUnconfiguredOwner(t *testing.T) {
+	tr := testTranslator()
+	tr.update(func(tb *table) { tb.put(testRepo(1, "example.com/a")) })
+
+	gr := testGHRepo(1, "a")
+	gr.Owner.Login = github.String("elsewhere")
+
+	if err := tr.UpdateRepo(context.Background(), 1, gr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.snapshot().repos[1] != nil {
+		t.Error("repo transferred to unconfigured owner still present")
+	}
+
+	if m := tr.Lookup("example.com/a"); m != nil {
+		t.Errorf("old path still served: %v", m)
+	}
+}
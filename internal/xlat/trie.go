@@ -0,0 +1,165 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import "strings"
+
+// trie is an immutable path trie keyed by slash-separated import path
+// elements. Updates return a new root that shares all untouched nodes with
+// the original, which keeps table clones cheap no matter how many modules
+// are being served.
+type trie struct {
+	mod  *Module
+	kids map[string]*trie
+}
+
+func splitPath(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool { return r == '/' })
+}
+
+// longest returns the Module registered for the longest prefix of p, or
+// nil if no prefix of p has been registered.
+func (n *trie) longest(p string) *Module {
+	var m *Module
+
+	for _, elem := range splitPath(p) {
+		if n = n.kids[elem]; n == nil {
+			break
+		}
+
+		if n.mod != nil {
+			m = n.mod
+		}
+	}
+
+	return m
+}
+
+// TraceStep records one level of a prefix match.
+type TraceStep struct {
+	Prefix string `json:"prefix"`
+	Found  bool   `json:"found"`
+}
+
+// trace is like longest but also returns each prefix of p that was tried,
+// and whether a module was registered for it. The walk stops at the first
+// prefix with no registered descendents.
+func (n *trie) trace(p string) (*Module, []TraceStep) {
+	var (
+		m     *Module
+		steps []TraceStep
+		pfx   string
+	)
+
+	for _, elem := range splitPath(p) {
+		if pfx == "" {
+			pfx = elem
+		} else {
+			pfx += "/" + elem
+		}
+
+		if n = n.kids[elem]; n == nil {
+			steps = append(steps, TraceStep{Prefix: pfx})
+			break
+		}
+
+		steps = append(steps, TraceStep{Prefix: pfx, Found: n.mod != nil})
+
+		if n.mod != nil {
+			m = n.mod
+		}
+	}
+
+	return m, steps
+}
+
+// get returns the Module registered for exactly p (or nil).
+func (n *trie) get(p string) *Module {
+	for _, elem := range splitPath(p) {
+		if n = n.kids[elem]; n == nil {
+			return nil
+		}
+	}
+
+	return n.mod
+}
+
+// put returns a copy of n with m registered under p.
+func (n *trie) put(p string, m *Module) *trie {
+	return n.with(splitPath(p), m)
+}
+
+// del returns a copy of n with no Module registered under p.
+func (n *trie) del(p string) *trie {
+	nn := n.with(splitPath(p), nil)
+	if nn == nil {
+		return &trie{}
+	}
+	return nn
+}
+
+// with returns a copy of n with the node at elems holding m. Nodes left
+// with neither a Module nor children are pruned (i.e. nil is returned).
+func (n *trie) with(elems []string, m *Module) *trie {
+	nn := &trie{}
+	if n != nil {
+		*nn = *n
+	}
+
+	if len(elems) == 0 {
+		nn.mod = m
+	} else {
+		kids := make(map[string]*trie, len(nn.kids)+1)
+		for k, v := range nn.kids {
+			kids[k] = v
+		}
+
+		var kid *trie
+		if n != nil {
+			kid = n.kids[elems[0]]
+		}
+
+		if kid = kid.with(elems[1:], m); kid != nil {
+			kids[elems[0]] = kid
+		} else {
+			delete(kids, elems[0])
+		}
+
+		nn.kids = kids
+	}
+
+	if nn.mod == nil && len(nn.kids) == 0 {
+		return nil
+	}
+
+	return nn
+}
+
+// walk calls fn for each registered Module in n.
+func (n *trie) walk(fn func(*Module)) {
+	if n.mod != nil {
+		fn(n.mod)
+	}
+
+	for _, kid := range n.kids {
+		kid.walk(fn)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package xlat
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func testTrie(paths ...string) (*trie, map[string]*Module) {
+	n := &trie{}
+	mods := make(map[string]*Module)
+
+	for i, p := range paths {
+		m := &Module{Repo: &Repo{id: int64(i + 1)}, path: p}
+		mods[p] = m
+		n = n.put(p, m)
+	}
+
+	return n, mods
+}
+
+func TestTrieLongest(t *testing.T) {
+	n, mods := testTrie("example.com/a", "example.com/a/b/c", "example.com/x")
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"example.com/a", "example.com/a"},
+		{"example.com/a/", "example.com/a"},
+		{"example.com/a/b", "example.com/a"},
+		{"example.com/a/b/c", "example.com/a/b/c"},
+		{"example.com/a/b/c/d/e", "example.com/a/b/c"},
+		{"example.com/ab", ""},
+		{"example.com", ""},
+		{"other.com/a", ""},
+		{"", ""},
+	} {
+		got := n.longest(tc.path)
+		if got != mods[tc.want] {
+			t.Errorf("longest(%q) = %v; want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestTrieTrace(t *testing.T) {
+	n, mods := testTrie("example.com/a", "example.com/a/b/c")
+
+	for _, tc := range []struct {
+		path  string
+		want  string
+		steps []TraceStep
+	}{
+		{
+			path: "example.com/a/b/c/d",
+			want: "example.com/a/b/c",
+			steps: []TraceStep{
+				{Prefix: "example.com"},
+				{Prefix: "example.com/a", Found: true},
+				{Prefix: "example.com/a/b"},
+				{Prefix: "example.com/a/b/c", Found: true},
+				{Prefix: "example.com/a/b/c/d"},
+			},
+		},
+		{
+			path: "example.com/z/y",
+			steps: []TraceStep{
+				{Prefix: "example.com"},
+				{Prefix: "example.com/z"},
+			},
+		},
+	} {
+		m, steps := n.trace(tc.path)
+		if m != mods[tc.want] {
+			t.Errorf("trace(%q) module = %v; want %q", tc.path, m, tc.want)
+		}
+
+		if !reflect.DeepEqual(steps, tc.steps) {
+			t.Errorf("trace(%q) steps = %+v; want %+v", tc.path, steps, tc.steps)
+		}
+	}
+}
+
+func TestTrieGet(t *testing.T) {
+	n, mods := testTrie("example.com/a", "example.com/a/b/c")
+
+	if got := n.get("example.com/a/b/c"); got != mods["example.com/a/b/c"] {
+		t.Errorf("get(a/b/c) = %v", got)
+	}
+
+	for _, p := range []string{"example.com/a/b", "example.com/a/b/c/d", "example.com"} {
+		if got := n.get(p); got != nil {
+			t.Errorf("get(%q) = %v; want nil", p, got)
+		}
+	}
+}
+
+func TestTrieDelPrunes(t *testing.T) {
+	n, _ := testTrie("example.com/a", "example.com/a/b/c")
+
+	n = n.del("example.com/a/b/c")
+	if kid := n.kids["example.com"].kids["a"]; len(kid.kids) != 0 {
+		t.Errorf("empty branch not pruned: %v", kid.kids)
+	}
+
+	// Deleting a path that was never added changes nothing.
+	if got := n.del("example.com/q"); got.get("example.com/a") == nil {
+		t.Error("del of unknown path dropped existing module")
+	}
+
+	n = n.del("example.com/a")
+	if n == nil {
+		t.Fatal("del returned nil root")
+	}
+
+	if n.mod != nil || len(n.kids) != 0 {
+		t.Errorf("root not empty after deleting all paths: %+v", n)
+	}
+
+	if got := n.longest("example.com/a"); got != nil {
+		t.Errorf("longest on empty trie = %v", got)
+	}
+}
+
+func TestTrieImmutable(t *testing.T) {
+	orig, mods := testTrie("example.com/a", "other.com/x")
+
+	upd := orig.put("example.com/a/b", &Module{path: "example.com/a/b"})
+	upd = upd.del("example.com/a")
+
+	if got := orig.get("example.com/a"); got != mods["example.com/a"] {
+		t.Errorf("original changed by del: get(a) = %v", got)
+	}
+
+	if got := orig.get("example.com/a/b"); got != nil {
+		t.Errorf("original changed by put: get(a/b) = %v", got)
+	}
+
+	if upd.get("example.com/a/b") == nil || upd.get("example.com/a") != nil {
+		t.Error("update not reflected in new trie")
+	}
+
+	// Untouched branches are shared rather than copied.
+	if orig.kids["other.com"] != upd.kids["other.com"] {
+		t.Error("untouched branch was copied")
+	}
+}
+
+func TestTrieWalk(t *testing.T) {
+	paths := []string{"example.com/a", "example.com/a/b", "other.com/x"}
+	n, _ := testTrie(paths...)
+
+	seen := make(map[string]bool)
+	n.walk(func(m *Module) { seen[m.path] = true })
+
+	if len(seen) != len(paths) {
+		t.Errorf("walk visited %v; want %v", seen, paths)
+	}
+}
+
+// mapLookup is the map based lookup that the trie replaced; it's kept
+// here only for comparison.
+func mapLookup(mods map[string]*Module, importPath string) *Module {
+	for name := trimVersion(importPath); name != "."; name = trimPackage(name) {
+		if m, ok := mods[name]; ok {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func trimPackage(name string) string {
+	name, _ = path.Split(path.Clean(name))
+	return path.Clean(name)
+}
+
+func benchPaths(n int) []string {
+	var out []string
+	for i := 0; i < n; i++ {
+		out = append(out, fmt.Sprintf("example.com/owner%d/repo%d", i%10, i))
+	}
+	return out
+}
+
+func BenchmarkLookupTrie(b *testing.B) {
+	n, _ := testTrie(benchPaths(1000)...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n.longest(trimVersion("example.com/owner7/repo517/internal/pkg/v2"))
+	}
+}
+
+func BenchmarkLookupMapWalk(b *testing.B) {
+	_, mods := testTrie(benchPaths(1000)...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mapLookup(mods, "example.com/owner7/repo517/internal/pkg/v2")
+	}
+}
@@ -24,17 +24,30 @@ import (
 	"errors"
 	"fmt"
 	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"toolman.org/base/log/v2"
 	"toolman.org/svc/build/go/gogetter/internal/config"
 )
 
 type Translator struct {
-	prefixes []string          // List of all configured pkg prefixes
-	ownrpfx  map[string]string // Github repo owner -> Go package prefix
-	repos    map[int64]*Repo   // Github repo id    -> *Repo
-	gopkgs   map[string]int64  // Go package name   -> Github repo id
+	prefixes []string                    // List of all configured pkg prefixes
+	ownrpfx  map[string]string           // Github repo owner -> Go package prefix
+	ownrdef  map[string]*config.TransDef // Github repo owner -> translator definition
+
+	mu    sync.Mutex   // Serializes table updates (readers never lock)
+	tbl   atomic.Value // Current *table; see table.go
+	synct int64        // Time of last successful discovery (UnixNano)
+	ready int32        // Non-zero once the table has been fully populated
+	creds int32        // Non-zero if Github has rejected our credentials
+
+	imu  sync.Mutex          // Protects info
+	info map[int64]*repoInfo // Github repo id -> cached contents; see detect.go
 
 	*config.Config
 }
@@ -42,12 +55,12 @@ type Translator struct {
 func New(cfg *config.Config) (*Translator, error) {
 	xlatr := &Translator{
 		ownrpfx: make(map[string]string),
-		repos:   make(map[int64]*Repo),
-		gopkgs:  make(map[string]int64),
-
-		Config: cfg,
+		ownrdef: make(map[string]*config.TransDef),
+		Config:  cfg,
 	}
 
+	xlatr.tbl.Store(newTable())
+
 	pset := make(map[string]bool)
 
 	for _, d := range cfg.Trans {
@@ -56,6 +69,7 @@ func New(cfg *config.Config) (*Translator, error) {
 				return nil, fmt.Errorf("multiple prefix mappings for repo owner %q: %q and %q", o, p, d.Prefix)
 			}
 			xlatr.ownrpfx[o] = d.Prefix
+			xlatr.ownrdef[o] = d
 			pset[d.Prefix] = true
 		}
 	}
@@ -74,22 +88,127 @@ func New(cfg *config.Config) (*Translator, error) {
 	return xlatr, nil
 }
 
-func (t *Translator) Lookup(importPath string) *Repo {
-	log.Infof("Lookup: %q", importPath)
-	for name := trimVersion(importPath); name != "."; name = trimPackage(name) {
-		log.Infof("name=%q", name)
-		if id, ok := t.gopkgs[name]; ok {
-			return t.repos[id]
+func (t *Translator) Lookup(importPath string) *Module {
+	start := time.Now()
+	tb, p := t.snapshot(), trimVersion(importPath)
+	m := t.withMoved(tb, p, tb.pkgs.longest(p))
+	lookupLatency.Observe(time.Since(start).Seconds())
+
+	if log.V(2) {
+		log.Infof("Lookup: %q -> %v", importPath, m != nil)
+	}
+
+	return m
+}
+
+// Trace is like Lookup but also returns the prefix levels of importPath
+// that were tried while resolving it.
+func (t *Translator) Trace(importPath string) (*Module, []TraceStep) {
+	tb, p := t.snapshot(), trimVersion(importPath)
+	m, steps := tb.pkgs.trace(p)
+	return t.withMoved(tb, p, m), steps
+}
+
+// withMoved returns whichever is the longer match for p: m (the longest
+// module registered in tb) or an old path retained after a rename or
+// transfer.
+func (t *Translator) withMoved(tb *table, p string, m *Module) *Module {
+	if a := tb.lookupMoved(p, t.RenameGrace); a != nil && (m == nil || len(a.path) > len(m.path)) {
+		return a
+	}
+
+	return m
+}
+
+// Repos returns all repos in the translation table, sorted by full name.
+func (t *Translator) Repos() []*Repo {
+	tb := t.snapshot()
+
+	out := make([]*Repo, 0, len(tb.repos))
+	for _, r := range tb.repos {
+		out = append(out, r)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].FullName() < out[j].FullName() })
+
+	return out
+}
+
+// Owner describes a configured Github repo owner.
+type Owner struct {
+	Name           string `json:"name"`
+	Prefix         string `json:"prefix"`
+	InstallationID int64  `json:"installation_id,omitempty"` // Zero if not installed
+}
+
+// Owners returns all configured repo owners, sorted by name.
+func (t *Translator) Owners() []*Owner {
+	tb := t.snapshot()
+
+	var out []*Owner
+	for o, p := range t.ownrpfx {
+		out = append(out, &Owner{Name: o, Prefix: p, InstallationID: tb.insts[o]})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
+
+// Prefixes returns all configured package prefixes in sorted order.
+func (t *Translator) Prefixes() []string {
+	out := append([]string(nil), t.prefixes...)
+	sort.Strings(out)
+	return out
+}
+
+// Modules returns all modules served under the given package prefix, sorted
+// by module path.
+func (t *Translator) Modules(prefix string) []*Module {
+	var out []*Module
+
+	t.snapshot().pkgs.walk(func(m *Module) {
+		if underPrefix(m.path, prefix) {
+			out = append(out, m)
+		}
+	})
+
+	sort.Slice(out, func(i, j int) bool { return out[i].path < out[j].path })
+
+	return out
+}
+
+// NearestPrefix returns the configured package prefix that best matches
+// importPath; either the longest prefix containing importPath or, failing
+// that, the shortest prefix on the same host. An empty string is returned
+// if no prefix shares importPath's host.
+func (t *Translator) NearestPrefix(importPath string) string {
+	host := strings.SplitN(importPath, "/", 2)[0]
+
+	var best string
+	for _, p := range t.prefixes {
+		if underPrefix(importPath, p) && len(p) > len(best) {
+			best = p
 		}
 	}
 
-	return nil
+	if best != "" {
+		return best
+	}
+
+	for _, p := range t.prefixes {
+		if strings.SplitN(p, "/", 2)[0] == host && (best == "" || len(p) < len(best)) {
+			best = p
+		}
+	}
+
+	return best
 }
 
 func (t *Translator) Dump() {
-	for pkg, id := range t.gopkgs {
-		log.Infof("%-45s %s", pkg, t.repos[id].goGetURL())
-	}
+	t.snapshot().pkgs.walk(func(m *Module) {
+		log.Infof("%-45s %s", m.path, m.goGetURL())
+	})
 }
 
 func trimVersion(name string) string {
@@ -101,8 +220,3 @@ func trimVersion(name string) string {
 	}
 	return name
 }
-
-func trimPackage(name string) string {
-	name, _ = path.Split(path.Clean(name))
-	return path.Clean(name)
-}
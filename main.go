@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/kr/pretty"
+	"github.com/spf13/pflag"
 
 	"toolman.org/base/log/v2"
 	"toolman.org/base/toolman/v2"
@@ -55,6 +56,10 @@ func run(ctx context.Context, cfg *config.Config) error {
 		return err
 	}
 
+	if args := pflag.Args(); len(args) != 0 {
+		return command(ctx, cfg, args)
+	}
+
 	c := *cfg
 	c.Config = nil
 	pretty.Println(c)
@@ -64,20 +69,24 @@ func run(ctx context.Context, cfg *config.Config) error {
 		return err
 	}
 
-	if err := x.Discover(ctx); err != nil {
-		return err
+	if err := x.LoadSnapshot(); err != nil {
+		log.Warningf("Ignoring snapshot: %v", err)
 	}
 
-	x.Dump()
+	// Discovery runs in the background so we can begin serving requests
+	// (with a 503 response) while the translation table is populated.
+	go x.Resync(ctx, cfg.Resync)
 
 	s := server.New(cfg, x)
 
 	toolman.RegisterShutdown(func() {
-		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
 		defer cancel()
 
-		s.Shutdown(ctx)
+		if err := s.Shutdown(ctx); err != nil {
+			log.Errorf("Server shutdown: %v", err)
+		}
 	})
 
-	return s.ListenAndServe()
+	return s.ListenAndServe(ctx)
 }